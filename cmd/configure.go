@@ -43,22 +43,35 @@ bookmark monitoring path, and check intervals.`,
 
 			util.Cyan.Println("\nCurrent daemon settings:")
 			util.Cyan.Printf("Daemon enabled: %t\n", cfg.DaemonEnabled)
-			util.Cyan.Printf("Bookmark path: %s\n", cfg.BookmarkPath)
+			if len(cfg.Providers) > 0 {
+				for _, provider := range cfg.Providers {
+					util.Cyan.Printf("Provider: %s (%s), enabled: %t\n", provider.Name, provider.ProviderType(), provider.Enabled)
+				}
+			} else {
+				util.Cyan.Printf("Bookmark path: %s\n", cfg.BookmarkPath)
+			}
 			util.Cyan.Printf("Check interval: %d minutes\n", cfg.CheckInterval)
 
 			util.CyanBold.Println("\nUpdate daemon configuration? (y/n):")
 			response := util.ScanlineTrim()
 
 			if response == "y" || response == "Y" || response == "yes" {
-				util.Cyan.Printf("Path to bookmark file/folder to monitor (current: %s, empty to disable): ", cfg.BookmarkPath)
-				newPath := util.ScanlineTrim()
+				newPath := ""
+				if len(cfg.Providers) > 0 {
+					util.Cyan.Printf("Bookmark providers are managed in the 'providers' block of %s\n", configPath)
+				} else {
+					util.Cyan.Printf("Path to bookmark file/folder to monitor (current: %s, empty to disable): ", cfg.BookmarkPath)
+					newPath = util.ScanlineTrim()
+				}
 
-				if newPath == "" {
+				if newPath == "" && len(cfg.Providers) == 0 {
 					cfg.DaemonEnabled = false
 					cfg.BookmarkPath = ""
 				} else {
 					cfg.DaemonEnabled = true
-					cfg.BookmarkPath = newPath
+					if newPath != "" {
+						cfg.BookmarkPath = newPath
+					}
 
 					util.Cyan.Printf("Check interval in minutes (current: %d): ", cfg.CheckInterval)
 					intervalStr := util.ScanlineTrim()
@@ -2,11 +2,14 @@ package cmd
 
 import (
 	"os"
+	"path/filepath"
 
 	"github.com/lithammer/dedent"
 	"github.com/ryan-gang/kindle-send-daemon/internal/classifier"
 	"github.com/ryan-gang/kindle-send-daemon/internal/cmdutil"
+	"github.com/ryan-gang/kindle-send-daemon/internal/epubgen"
 	"github.com/ryan-gang/kindle-send-daemon/internal/handler"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
 	"github.com/ryan-gang/kindle-send-daemon/internal/util"
 	"github.com/spf13/cobra"
 )
@@ -17,8 +20,11 @@ func init() {
 
 var (
 	helpDownload = `Downloads the webpage or collection of webpages from given arguments
-that can be a standalone link or a text file containing multiple links.
-Supports multiple arguments. Each argument is downloaded as a separate file.`
+that can be a standalone link, a text file containing multiple links or a
+bookmarks export (browser bookmarks.html or JSON, OPML).
+Supports multiple arguments. Each argument is downloaded as a separate file.
+With --format md or html every page is saved as a file of its own, a collection
+of webpages goes to a folder.`
 
 	exampleDownload = dedent.Dedent(`
 		# Download a single webpage
@@ -28,10 +34,21 @@ Supports multiple arguments. Each argument is downloaded as a separate file.`
 		kindle-send download "http://paulgraham.com/alien.html" "http://paulgraham.com/hwh.html"
 
 		# Download webpage and collection of webpages
-		kindle-send download "http://paulgraham.com/alien.html" links.txt`,
+		kindle-send download "http://paulgraham.com/alien.html" links.txt
+
+		# Download a webpage as azw3 to copy to the kindle over USB
+		kindle-send download --format azw3 "http://paulgraham.com/alien.html"
+
+		# Save every page of a collection as Markdown, or as HTML with its images
+		kindle-send download --format md links.txt
+		kindle-send download --format html links.txt`,
 	)
 )
 
+func init() {
+	cmdutil.AddFormatFlag(downloadCmd, epubgen.Formats)
+}
+
 var downloadCmd = &cobra.Command{
 	Use:     "download [LINK1] [LINK2] [FILE1] [FILE2]",
 	Short:   "Download the webpage as ebook and save locally",
@@ -43,17 +60,24 @@ var downloadCmd = &cobra.Command{
 			return
 		}
 
-		downloadRequests := classifier.Classify(args)
-		downloadedRequests := handler.Queue(downloadRequests)
+		downloadRequests, rejected := classifier.Classify(args)
+		cmdutil.ReportRejected(rejected)
+		if err := cmdutil.ApplyFormatFlag(cmd, cfg, downloadRequests, epubgen.Formats); err != nil {
+			util.LogError(util.ConfigError, "reading --format", err)
+			os.Exit(1)
+		}
+		results := handler.Queue(cmd.Context(), downloadRequests)
 
-		util.CyanBold.Printf("Downloaded %d files :\n", len(downloadRequests))
-		for idx, req := range downloadedRequests {
-			fileInfo, err := os.Stat(req.Path)
-			if err != nil {
-				util.Red.Printf("Error getting file info for %s: %v\n", req.Path, err)
-				continue
+		var downloaded []types.Result
+		for _, result := range results {
+			if result.Status == types.StatusDownloaded {
+				downloaded = append(downloaded, result)
 			}
-			util.Cyan.Printf("%d. %s\n", idx+1, fileInfo.Name())
+		}
+
+		util.CyanBold.Printf("Downloaded %d files :\n", len(downloaded))
+		for idx, result := range downloaded {
+			util.Cyan.Printf("%d. %s (%d KB)\n", idx+1, filepath.Base(result.Path), result.Size/1024)
 		}
 
 	},
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/ryan-gang/kindle-send-daemon/internal/cmdutil"
+	"github.com/ryan-gang/kindle-send-daemon/internal/daemon"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
+	"github.com/ryan-gang/kindle-send-daemon/internal/util"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyForgetCmd)
+
+	historyCmd.Flags().String("since", "", "Only show bookmarks processed after this date (2006-01-02, RFC3339 or a duration like 7d, 12h)")
+	historyCmd.Flags().String("until", "", "Only show bookmarks processed up to this date, a plain date includes that day")
+	historyCmd.Flags().String("status", "", "Only show bookmarks with this status (sent, download_failed, mail_failed, skipped)")
+	historyCmd.Flags().String("domain", "", "Only show bookmarks from this domain or its subdomains")
+	historyCmd.Flags().String("provider", "", "Only show bookmarks from this provider")
+	historyCmd.Flags().StringP("output", "o", "table", "Output format, table or json")
+	historyCmd.Flags().IntP("limit", "n", 0, "Show at most this many bookmarks, 0 for all")
+}
+
+var exampleHistory = dedent.Dedent(`
+	# Everything the daemon processed in the last week
+	kindle-send history --since 7d
+
+	# Links from one site that failed to download
+	kindle-send history --domain paulgraham.com --status download_failed
+
+	# Machine readable output
+	kindle-send history --provider raindrop -o json
+
+	# Send a link again on the next daemon cycle
+	kindle-send history forget "http://paulgraham.com/hwh.html"`,
+)
+
+var historyCmd = &cobra.Command{
+	Use:     "history",
+	Short:   "Show bookmarks processed by the daemon",
+	Long:    `Lists the bookmarks the daemon has processed, along with what happened to each of them.`,
+	Example: exampleHistory,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := cmdutil.LoadConfigOrExit(cmd)
+		if cfg == nil {
+			os.Exit(1)
+		}
+
+		filter, err := historyFilterFromFlags(cmd)
+		if err != nil {
+			util.LogError(util.ValidationError, "parsing history filters", err)
+			os.Exit(1)
+		}
+
+		entries, err := daemon.OpenHistory(cfg).History(filter)
+		if err != nil {
+			util.LogError(util.FileError, "reading history", err)
+			os.Exit(1)
+		}
+		if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		switch output {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if entries == nil {
+				entries = []daemon.ProcessedBookmark{}
+			}
+			if err := encoder.Encode(entries); err != nil {
+				util.LogError(util.FileError, "encoding history", err)
+				os.Exit(1)
+			}
+		case "table":
+			printHistoryTable(entries)
+		default:
+			util.LogErrorf(util.ValidationError, "parsing flags", "unknown output format %q, use table or json", output)
+			os.Exit(1)
+		}
+	},
+}
+
+var historyForgetCmd = &cobra.Command{
+	Use:   "forget [URL]",
+	Short: "Forget a processed bookmark so it is sent again",
+	Long:  `Removes a bookmark from the processed history and the retry queue, the daemon sends it again on its next cycle.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := cmdutil.LoadConfigOrExit(cmd)
+		if cfg == nil {
+			os.Exit(1)
+		}
+
+		found, err := daemon.OpenHistory(cfg).Forget(args[0])
+		if err != nil {
+			util.LogError(util.FileError, "saving history", err)
+			os.Exit(1)
+		}
+		if !found {
+			util.Red.Printf("%s is not in the history\n", args[0])
+			os.Exit(1)
+		}
+		util.Green.Printf("Forgot %s, it will be sent on the next daemon cycle\n", args[0])
+	},
+}
+
+func historyFilterFromFlags(cmd *cobra.Command) (daemon.HistoryFilter, error) {
+	var filter daemon.HistoryFilter
+	var err error
+
+	since, _ := cmd.Flags().GetString("since")
+	if filter.Since, err = parseHistoryTime(since); err != nil {
+		return filter, fmt.Errorf("invalid --since: %w", err)
+	}
+	until, _ := cmd.Flags().GetString("until")
+	if filter.Until, err = parseHistoryUntil(until); err != nil {
+		return filter, fmt.Errorf("invalid --until: %w", err)
+	}
+
+	status, _ := cmd.Flags().GetString("status")
+	switch types.Status(status) {
+	case "", types.StatusSent, types.StatusDownloadFailed, types.StatusMailFailed, types.StatusSkipped:
+		filter.Status = types.Status(status)
+	default:
+		return filter, fmt.Errorf("unknown status %q", status)
+	}
+
+	filter.Domain, _ = cmd.Flags().GetString("domain")
+	filter.Provider, _ = cmd.Flags().GetString("provider")
+	return filter, nil
+}
+
+// parseHistoryTime accepts a date, an RFC3339 timestamp or a duration back from now such as 7d or 12h
+func parseHistoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, timestamp or duration", value)
+}
+
+// parseHistoryUntil is parseHistoryTime for the end of a range, a plain date includes the whole of that day
+func parseHistoryUntil(value string) (time.Time, error) {
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return parseHistoryTime(value)
+}
+
+func printHistoryTable(entries []daemon.ProcessedBookmark) {
+	if len(entries) == 0 {
+		util.Cyan.Println("No processed bookmarks match")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROCESSED\tSTATUS\tPROVIDER\tTITLE\tURL")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Timestamp.Local().Format("2006-01-02 15:04"),
+			entry.EffectiveStatus(),
+			orDash(entry.Source),
+			orDash(truncate(entry.Title, 50)),
+			entry.URL,
+		)
+	}
+	w.Flush()
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", time.Time{}},
+		{"2024-05-01T12:30:00Z", time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"12h", now.Add(-12 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryTime(tt.value)
+		if err != nil {
+			t.Errorf("parseHistoryTime(%q): %v", tt.value, err)
+			continue
+		}
+		// Durations count back from the moment of parsing
+		if diff := got.Sub(tt.want); diff < -time.Minute || diff > time.Minute {
+			t.Errorf("parseHistoryTime(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"yesterday", "7 days", "2024-13-01", "d"} {
+		if _, err := parseHistoryTime(value); err == nil {
+			t.Errorf("parseHistoryTime(%q) accepted", value)
+		}
+	}
+}
+
+func TestParseHistoryUntil(t *testing.T) {
+	end, err := parseHistoryUntil("2024-05-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bookmarks processed late on the day are included, the next day is not
+	lastMinute := time.Date(2024, 5, 1, 23, 59, 0, 0, time.Local)
+	nextDay := time.Date(2024, 5, 2, 0, 0, 0, 0, time.Local)
+	if end.Before(lastMinute) || !end.Before(nextDay) {
+		t.Errorf("parseHistoryUntil(2024-05-01) = %s, want the end of that day", end)
+	}
+
+	stamp := "2024-05-01T12:30:00Z"
+	if got, err := parseHistoryUntil(stamp); err != nil || !got.Equal(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)) {
+		t.Errorf("parseHistoryUntil(%s) = %s, %v", stamp, got, err)
+	}
+}
@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/lithammer/dedent"
 	"github.com/ryan-gang/kindle-send-daemon/internal/classifier"
 	"github.com/ryan-gang/kindle-send-daemon/internal/cmdutil"
+	"github.com/ryan-gang/kindle-send-daemon/internal/epubgen"
 	"github.com/ryan-gang/kindle-send-daemon/internal/handler"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
+	"github.com/ryan-gang/kindle-send-daemon/internal/util"
 	"github.com/spf13/cobra"
 )
 
@@ -32,6 +37,7 @@ kindle-send auto detects if argument is a link, collection of links or an ebook.
 
 func init() {
 	sendCmd.PersistentFlags().IntP("mail-timeout", "m", 120, "Mail timeout in seconds, increase it if sending lot of files")
+	cmdutil.AddFormatFlag(sendCmd, epubgen.MailFormats)
 }
 
 var sendCmd = &cobra.Command{
@@ -45,15 +51,28 @@ var sendCmd = &cobra.Command{
 			return
 		}
 
-		downloadRequests := classifier.Classify(args)
-		downloadedRequests := handler.Queue(downloadRequests)
+		downloadRequests, rejected := classifier.ClassifyMail(args)
+		cmdutil.ReportRejected(rejected)
+		if err := cmdutil.ApplyFormatFlag(cmd, cfg, downloadRequests, epubgen.MailFormats); err != nil {
+			util.LogError(util.ConfigError, "reading --format", err)
+			os.Exit(1)
+		}
+		results := handler.Queue(cmd.Context(), downloadRequests)
 
 		timeout, err := cmd.Flags().GetInt("mail-timeout")
 		if err != nil {
 			timeout = 0
 		}
 
-		handler.Mail(downloadedRequests, timeout)
+		results = handler.Mail(results, timeout)
+		if len(rejected) > 0 {
+			os.Exit(1)
+		}
+		for _, result := range results {
+			if result.Status != types.StatusSent {
+				os.Exit(1)
+			}
+		}
 
 	},
 }
@@ -15,18 +15,20 @@ import (
 
 // FileProvider implements the Provider interface for file-based bookmarks
 type FileProvider struct {
+	name    string
 	path    string
 	enabled bool
 }
 
 func NewFileProvider() *FileProvider {
 	return &FileProvider{
+		name:    "file",
 		enabled: false,
 	}
 }
 
 func (fp *FileProvider) Name() string {
-	return "file"
+	return fp.name
 }
 
 func (fp *FileProvider) IsEnabled() bool {
@@ -0,0 +1,27 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+)
+
+// New creates an unconfigured provider of the given type, it satisfies bookmarks.Factory
+func New(providerType, name string) (bookmarks.Provider, error) {
+	switch providerType {
+	case "file":
+		provider := NewFileProvider()
+		provider.name = name
+		return provider, nil
+	case "raindrop":
+		provider := NewRaindropProvider()
+		provider.name = name
+		return provider, nil
+	case "safari":
+		provider := NewSafariProvider()
+		provider.name = name
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", providerType)
+	}
+}
@@ -1,4 +1,181 @@
 package providers
 
-// TODO: Implement Raindrop.io bookmark provider
-// This will use the Raindrop API to fetch bookmarks
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+)
+
+const (
+	DefaultRaindropBaseURL = "https://api.raindrop.io/rest/v1"
+	// Raindrop rejects page sizes above 50
+	raindropMaxPerPage = 50
+)
+
+// RaindropProvider implements the Provider interface for Raindrop.io collections
+type RaindropProvider struct {
+	name         string
+	token        string
+	collectionID int
+	baseURL      string
+	perPage      int
+	enabled      bool
+	client       *http.Client
+}
+
+type raindropItem struct {
+	Link    string    `json:"link"`
+	Title   string    `json:"title"`
+	Tags    []string  `json:"tags"`
+	Created time.Time `json:"created"`
+}
+
+type raindropResponse struct {
+	Result       bool           `json:"result"`
+	ErrorMessage string         `json:"errorMessage"`
+	Items        []raindropItem `json:"items"`
+	Count        int            `json:"count"`
+}
+
+func NewRaindropProvider() *RaindropProvider {
+	return &RaindropProvider{
+		name:    "raindrop",
+		baseURL: DefaultRaindropBaseURL,
+		perPage: raindropMaxPerPage,
+		enabled: false,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (rp *RaindropProvider) Name() string {
+	return rp.name
+}
+
+func (rp *RaindropProvider) IsEnabled() bool {
+	return rp.enabled && rp.token != ""
+}
+
+// Configure configures the raindrop provider with the given settings.
+// Recognised settings are token (required), collection_id, base_url and per_page.
+// Collection 0 means all bookmarks, -1 is Unsorted.
+func (rp *RaindropProvider) Configure(config map[string]interface{}) error {
+	token, ok := config["token"].(string)
+	if !ok || token == "" {
+		return fmt.Errorf("raindrop provider requires 'token' setting")
+	}
+
+	if value, ok := config["collection_id"]; ok {
+		id, err := intSetting(value)
+		if err != nil {
+			return fmt.Errorf("invalid 'collection_id' setting: %v", err)
+		}
+		rp.collectionID = id
+	}
+
+	if baseURL, ok := config["base_url"].(string); ok && baseURL != "" {
+		rp.baseURL = strings.TrimRight(baseURL, "/")
+	}
+
+	if value, ok := config["per_page"]; ok {
+		perPage, err := intSetting(value)
+		if err != nil {
+			return fmt.Errorf("invalid 'per_page' setting: %v", err)
+		}
+		if perPage <= 0 || perPage > raindropMaxPerPage {
+			return fmt.Errorf("'per_page' must be between 1 and %d", raindropMaxPerPage)
+		}
+		rp.perPage = perPage
+	}
+
+	rp.token = token
+	rp.enabled = true
+	return nil
+}
+
+// GetBookmarks pages through the configured collection and returns every bookmark in it
+func (rp *RaindropProvider) GetBookmarks(ctx context.Context) ([]bookmarks.Bookmark, error) {
+	if !rp.IsEnabled() {
+		return nil, fmt.Errorf("raindrop provider is not enabled or configured")
+	}
+
+	var allBookmarks []bookmarks.Bookmark
+	for page := 0; ; page++ {
+		resp, err := rp.fetchPage(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range resp.Items {
+			if item.Link == "" {
+				continue
+			}
+			allBookmarks = append(allBookmarks, bookmarks.Bookmark{
+				URL:       item.Link,
+				Title:     item.Title,
+				Tags:      item.Tags,
+				Source:    rp.Name(),
+				Timestamp: item.Created,
+			})
+		}
+
+		if len(resp.Items) < rp.perPage || (page+1)*rp.perPage >= resp.Count {
+			break
+		}
+	}
+
+	return allBookmarks, nil
+}
+
+func (rp *RaindropProvider) fetchPage(ctx context.Context, page int) (*raindropResponse, error) {
+	url := fmt.Sprintf("%s/raindrops/%d?page=%d&perpage=%d", rp.baseURL, rp.collectionID, page, rp.perPage)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+rp.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting raindrop page %d: %v", page, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("raindrop API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result raindropResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding raindrop response: %v", err)
+	}
+	if !result.Result {
+		return nil, fmt.Errorf("raindrop API error: %s", result.ErrorMessage)
+	}
+
+	return &result, nil
+}
+
+// intSetting accepts the numeric forms a setting can take after JSON decoding or manual construction
+func intSetting(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	default:
+		return 0, fmt.Errorf("unsupported type %T", value)
+	}
+}
@@ -0,0 +1,108 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newRaindropServer serves total items from collection 42, perPage at a time
+func newRaindropServer(t *testing.T, total int) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"result":false,"errorMessage":"Unauthorized"}`)
+			return
+		}
+		if r.URL.Path != "/raindrops/42" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("perpage"))
+
+		var items []raindropItem
+		for i := page * perPage; i < total && i < (page+1)*perPage; i++ {
+			items = append(items, raindropItem{
+				Link:    fmt.Sprintf("https://example.com/%d", i),
+				Title:   fmt.Sprintf("Article %d", i),
+				Tags:    []string{"reading"},
+				Created: time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+			})
+		}
+		json.NewEncoder(w).Encode(raindropResponse{Result: true, Items: items, Count: total})
+	}))
+}
+
+func TestRaindropProviderPagesThroughCollection(t *testing.T) {
+	server := newRaindropServer(t, 5)
+	defer server.Close()
+
+	rp := NewRaindropProvider()
+	err := rp.Configure(map[string]interface{}{
+		"token":         "secret",
+		"collection_id": float64(42),
+		"base_url":      server.URL,
+		"per_page":      2,
+	})
+	if err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	got, err := rp.GetBookmarks(context.Background())
+	if err != nil {
+		t.Fatalf("GetBookmarks: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("got %d bookmarks, want 5", len(got))
+	}
+
+	last := got[4]
+	if last.URL != "https://example.com/4" || last.Title != "Article 4" {
+		t.Errorf("unexpected bookmark %+v", last)
+	}
+	if last.Source != "raindrop" {
+		t.Errorf("source = %q, want raindrop", last.Source)
+	}
+	if len(last.Tags) != 1 || last.Tags[0] != "reading" {
+		t.Errorf("tags = %v, want [reading]", last.Tags)
+	}
+	if !last.Timestamp.Equal(time.Date(2024, 1, 1, 0, 0, 4, 0, time.UTC)) {
+		t.Errorf("timestamp = %v", last.Timestamp)
+	}
+}
+
+func TestRaindropProviderReportsAPIErrors(t *testing.T) {
+	server := newRaindropServer(t, 1)
+	defer server.Close()
+
+	rp := NewRaindropProvider()
+	err := rp.Configure(map[string]interface{}{
+		"token":         "wrong",
+		"collection_id": "42",
+		"base_url":      server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	if _, err := rp.GetBookmarks(context.Background()); err == nil {
+		t.Fatal("expected error for unauthorized token")
+	}
+}
+
+func TestRaindropProviderRequiresToken(t *testing.T) {
+	rp := NewRaindropProvider()
+	if err := rp.Configure(map[string]interface{}{}); err == nil {
+		t.Fatal("expected error without token")
+	}
+	if rp.IsEnabled() {
+		t.Fatal("provider should stay disabled without token")
+	}
+}
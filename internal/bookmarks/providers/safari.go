@@ -1,4 +1,178 @@
 package providers
 
-// TODO: Implement Safari bookmark provider
-// This will read bookmarks from Safari's bookmark database
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+	"howett.net/plist"
+)
+
+const (
+	// DefaultSafariFolder selects Safari's Reading List
+	DefaultSafariFolder = "Reading List"
+
+	safariReadingListTitle = "com.apple.ReadingList"
+	safariTypeList         = "WebBookmarkTypeList"
+	safariTypeLeaf         = "WebBookmarkTypeLeaf"
+)
+
+// Safari stores its top level folders under internal names, map them to what the UI shows
+var safariFolderAliases = map[string]string{
+	"reading list":   safariReadingListTitle,
+	"favorites":      "BookmarksBar",
+	"favourites":     "BookmarksBar",
+	"bookmarks menu": "BookmarksMenu",
+}
+
+// safariNode mirrors a single entry of Safari's Bookmarks.plist, folders and bookmarks share the layout
+type safariNode struct {
+	Title         string `plist:"Title"`
+	Type          string `plist:"WebBookmarkType"`
+	URLString     string `plist:"URLString"`
+	URIDictionary struct {
+		Title string `plist:"title"`
+	} `plist:"URIDictionary"`
+	ReadingList struct {
+		DateAdded time.Time `plist:"DateAdded"`
+	} `plist:"ReadingList"`
+	Children []safariNode `plist:"Children"`
+}
+
+// SafariProvider implements the Provider interface for Safari's Bookmarks.plist
+type SafariProvider struct {
+	name    string
+	path    string
+	folder  string
+	enabled bool
+}
+
+func NewSafariProvider() *SafariProvider {
+	return &SafariProvider{
+		name:    "safari",
+		folder:  DefaultSafariFolder,
+		enabled: false,
+	}
+}
+
+// DefaultSafariBookmarksPath returns where Safari keeps its bookmarks on macOS
+func DefaultSafariBookmarksPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, "Library", "Safari", "Bookmarks.plist")
+}
+
+func (sp *SafariProvider) Name() string {
+	return sp.name
+}
+
+func (sp *SafariProvider) IsEnabled() bool {
+	return sp.enabled && sp.path != ""
+}
+
+// Configure configures the safari provider with the given settings.
+// path defaults to Safari's own Bookmarks.plist, folder defaults to the Reading List.
+// Nested folders are addressed with slashes, eg. "Favorites/Tech".
+func (sp *SafariProvider) Configure(config map[string]interface{}) error {
+	path, _ := config["path"].(string)
+	if path == "" {
+		path = DefaultSafariBookmarksPath()
+	}
+	if path == "" {
+		return fmt.Errorf("safari provider requires 'path' setting")
+	}
+
+	if folder, ok := config["folder"].(string); ok && folder != "" {
+		sp.folder = folder
+	}
+
+	sp.path = path
+	sp.enabled = true
+	return nil
+}
+
+// GetBookmarks reads the configured folder, including its subfolders, from Bookmarks.plist
+func (sp *SafariProvider) GetBookmarks(ctx context.Context) ([]bookmarks.Bookmark, error) {
+	if !sp.IsEnabled() {
+		return nil, fmt.Errorf("safari provider is not enabled or configured")
+	}
+
+	data, err := os.ReadFile(sp.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading safari bookmarks: %v", err)
+	}
+
+	// plist detects binary and XML encodings on its own
+	var root safariNode
+	if _, err := plist.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("error parsing safari bookmarks: %v", err)
+	}
+
+	folder, err := findSafariFolder(&root, sp.folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var allBookmarks []bookmarks.Bookmark
+	sp.collect(folder, &allBookmarks)
+	return allBookmarks, nil
+}
+
+func (sp *SafariProvider) collect(node *safariNode, out *[]bookmarks.Bookmark) {
+	for i := range node.Children {
+		child := &node.Children[i]
+		switch child.Type {
+		case safariTypeList:
+			sp.collect(child, out)
+		case safariTypeLeaf:
+			if !strings.HasPrefix(child.URLString, "http://") && !strings.HasPrefix(child.URLString, "https://") {
+				continue
+			}
+			// Only Reading List entries carry a date, plain bookmarks fall back to now like the file provider
+			timestamp := child.ReadingList.DateAdded
+			if timestamp.IsZero() {
+				timestamp = time.Now()
+			}
+			*out = append(*out, bookmarks.Bookmark{
+				URL:       child.URLString,
+				Title:     child.URIDictionary.Title,
+				Source:    sp.Name(),
+				Timestamp: timestamp,
+			})
+		}
+	}
+}
+
+// findSafariFolder walks a slash separated folder path starting at root
+func findSafariFolder(root *safariNode, folderPath string) (*safariNode, error) {
+	current := root
+	for _, part := range strings.Split(folderPath, "/") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if alias, ok := safariFolderAliases[strings.ToLower(part)]; ok && current == root {
+			part = alias
+		}
+
+		var next *safariNode
+		for i := range current.Children {
+			child := &current.Children[i]
+			if child.Type == safariTypeList && strings.EqualFold(child.Title, part) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("safari folder %q not found", folderPath)
+		}
+		current = next
+	}
+	return current, nil
+}
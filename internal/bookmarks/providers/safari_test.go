@@ -0,0 +1,101 @@
+package providers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"howett.net/plist"
+)
+
+const safariFixture = "testdata/Bookmarks.plist"
+
+func readSafari(t *testing.T, path, folder string) []string {
+	t.Helper()
+	sp := NewSafariProvider()
+	if err := sp.Configure(map[string]interface{}{"path": path, "folder": folder}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	got, err := sp.GetBookmarks(context.Background())
+	if err != nil {
+		t.Fatalf("GetBookmarks: %v", err)
+	}
+	var urls []string
+	for _, b := range got {
+		urls = append(urls, b.URL)
+	}
+	return urls
+}
+
+func TestSafariProviderReadingList(t *testing.T) {
+	sp := NewSafariProvider()
+	if err := sp.Configure(map[string]interface{}{"path": safariFixture}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	got, err := sp.GetBookmarks(context.Background())
+	if err != nil {
+		t.Fatalf("GetBookmarks: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d bookmarks, want 2", len(got))
+	}
+
+	first := got[0]
+	if first.Title != "Fixing Performance Regressions Before they Happen" {
+		t.Errorf("title = %q", first.Title)
+	}
+	if first.Source != "safari" {
+		t.Errorf("source = %q, want safari", first.Source)
+	}
+	if !first.Timestamp.Equal(time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)) {
+		t.Errorf("timestamp = %v", first.Timestamp)
+	}
+}
+
+func TestSafariProviderNamedFolder(t *testing.T) {
+	all := readSafari(t, safariFixture, "Favorites")
+	if len(all) != 2 {
+		t.Fatalf("Favorites: got %v, want apple.com and the nested essay", all)
+	}
+
+	nested := readSafari(t, safariFixture, "Favorites/Essays")
+	if len(nested) != 1 || nested[0] != "http://paulgraham.com/greatwork.html" {
+		t.Fatalf("Favorites/Essays: got %v", nested)
+	}
+}
+
+func TestSafariProviderBinaryPlist(t *testing.T) {
+	data, err := os.ReadFile(safariFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree interface{}
+	if _, err := plist.Unmarshal(data, &tree); err != nil {
+		t.Fatal(err)
+	}
+	binary, err := plist.Marshal(tree, plist.BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "Bookmarks.plist")
+	if err := os.WriteFile(path, binary, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readSafari(t, path, ""); len(got) != 2 {
+		t.Fatalf("got %v, want the two reading list entries", got)
+	}
+}
+
+func TestSafariProviderMissingFolder(t *testing.T) {
+	sp := NewSafariProvider()
+	if err := sp.Configure(map[string]interface{}{"path": safariFixture, "folder": "Nope"}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if _, err := sp.GetBookmarks(context.Background()); err == nil {
+		t.Fatal("expected error for unknown folder")
+	}
+}
@@ -20,6 +20,31 @@ func NewRegistry() *Registry {
 	}
 }
 
+// NewRegistryFromConfig creates a registry holding one provider per config entry.
+// Disabled entries are registered but left unconfigured, so they never report as enabled.
+func NewRegistryFromConfig(configs []ProviderConfig, factory Factory) (*Registry, error) {
+	registry := NewRegistry()
+
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("provider entry of type %q has no name", config.Type)
+		}
+
+		provider, err := factory(config.ProviderType(), config.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create provider %s: %v", config.Name, err)
+		}
+		if err := registry.Register(provider); err != nil {
+			return nil, err
+		}
+		if err := registry.Configure(config.Name, config); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
 // Register adds a provider to the registry
 func (r *Registry) Register(provider Provider) error {
 	r.mu.Lock()
@@ -79,6 +104,11 @@ func (r *Registry) Configure(name string, config ProviderConfig) error {
 		return fmt.Errorf("provider %s not found", name)
 	}
 
+	if !config.Enabled {
+		r.configs[name] = config
+		return nil
+	}
+
 	if err := provider.Configure(config.Settings); err != nil {
 		return fmt.Errorf("failed to configure provider %s: %v", name, err)
 	}
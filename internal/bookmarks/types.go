@@ -9,6 +9,7 @@ import (
 type Bookmark struct {
 	URL       string    `json:"url"`
 	Title     string    `json:"title"`
+	Tags      []string  `json:"tags,omitempty"`
 	Source    string    `json:"source"` // Which provider this came from
 	Timestamp time.Time `json:"timestamp"`
 }
@@ -28,9 +29,23 @@ type Provider interface {
 	Configure(config map[string]interface{}) error
 }
 
-// ProviderConfig holds configuration for a provider
+// ProviderConfig holds configuration for a provider.
+// Name identifies the provider instance and Type selects its implementation,
+// Type may be omitted when the name is the type itself (eg. "raindrop").
 type ProviderConfig struct {
 	Name     string                 `json:"name"`
+	Type     string                 `json:"type,omitempty"`
 	Enabled  bool                   `json:"enabled"`
 	Settings map[string]interface{} `json:"settings"`
 }
+
+// ProviderType returns the implementation this config refers to
+func (pc ProviderConfig) ProviderType() string {
+	if pc.Type != "" {
+		return pc.Type
+	}
+	return pc.Name
+}
+
+// Factory creates an unconfigured provider of the given type registered under name
+type Factory func(providerType, name string) (Provider, error)
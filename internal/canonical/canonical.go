@@ -0,0 +1,277 @@
+package canonical
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DefaultTrackingParams are query parameters that only identify the campaign or share that led to a page.
+// A trailing * matches any parameter with that prefix. Generic names like ref or source also select content on
+// some sites, they are left to strip_params.
+var DefaultTrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "gclsrc", "msclkid", "yclid", "twclid", "igshid",
+	"mc_cid", "mc_eid", "_ga", "_gl", "_hsenc", "_hsmi", "hsctatracking", "mkt_tok",
+	"ref_src", "ref_url", "s_cid", "oly_anon_id", "oly_enc_id", "vero_id", "wickedid",
+}
+
+// Rules controls how urls are canonicalized
+type Rules struct {
+	// StripParams are removed from the query on top of DefaultTrackingParams, a trailing * matches a prefix
+	StripParams []string
+	// KeepParams are never removed, even when a strip rule matches them
+	KeepParams []string
+	// KeepFragment keeps #fragments, hash-bang (#!) routes are always kept
+	KeepFragment bool
+	// FollowRelCanonical fetches the page and prefers its <link rel="canonical">
+	FollowRelCanonical bool
+}
+
+// Normalize rewrites u into a canonical form meant for comparing urls, not for fetching them:
+// the scheme is always https, host case, www., mobile and AMP mirrors, default ports, tracking
+// parameters, trailing slashes and fragments are all normalised away.
+func Normalize(u string, rules Rules) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("not a web url: %s", u)
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("url has no host: %s", u)
+	}
+
+	parsed = unwrapAMPCache(parsed)
+
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	port := parsed.Port()
+	if port == "80" || port == "443" {
+		port = ""
+	}
+	host = stripMirrorPrefix(host)
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	parsed.Scheme = "https"
+	parsed.Host = host
+	parsed.User = nil
+	parsed.Path = normalizePath(parsed.Path)
+	parsed.RawPath = ""
+	parsed.RawQuery = normalizeQuery(parsed.Query(), rules)
+
+	if !strings.HasPrefix(parsed.Fragment, "!") && !rules.KeepFragment {
+		parsed.Fragment = ""
+	}
+	parsed.RawFragment = ""
+
+	return parsed.String(), nil
+}
+
+// unwrapAMPCache maps Google AMP viewer and cache urls back to the publisher url
+func unwrapAMPCache(u *url.URL) *url.URL {
+	host := strings.ToLower(u.Hostname())
+	var inner string
+	switch {
+	case (host == "www.google.com" || host == "google.com") && strings.HasPrefix(u.Path, "/amp/s/"):
+		inner = strings.TrimPrefix(u.Path, "/amp/s/")
+	case strings.HasSuffix(host, ".cdn.ampproject.org") && strings.HasPrefix(u.Path, "/c/s/"):
+		inner = strings.TrimPrefix(u.Path, "/c/s/")
+	case strings.HasSuffix(host, ".cdn.ampproject.org") && strings.HasPrefix(u.Path, "/v/s/"):
+		inner = strings.TrimPrefix(u.Path, "/v/s/")
+	default:
+		return u
+	}
+
+	unwrapped, err := url.Parse("https://" + inner)
+	if err != nil || unwrapped.Host == "" {
+		return u
+	}
+	unwrapped.RawQuery = u.RawQuery
+	unwrapped.Fragment = u.Fragment
+	return unwrapped
+}
+
+// stripMirrorPrefix folds www., mobile and AMP subdomains into the main host
+func stripMirrorPrefix(host string) string {
+	for _, prefix := range []string{"www.", "m.", "mobile.", "amp."} {
+		if trimmed, ok := strings.CutPrefix(host, prefix); ok && strings.Contains(trimmed, ".") {
+			return trimmed
+		}
+	}
+	return host
+}
+
+func normalizePath(p string) string {
+	// AMP variants usually live at <article>/amp or <article>.amp.html, a page at /amp is no variant
+	if trimmed, ok := strings.CutSuffix(strings.TrimSuffix(p, "/"), "/amp"); ok && trimmed != "" {
+		p = trimmed
+	}
+	if strings.HasSuffix(p, ".amp.html") {
+		p = strings.TrimSuffix(p, ".amp.html") + ".html"
+	}
+	p = strings.TrimRight(p, "/")
+	if p == "" {
+		return ""
+	}
+	return p
+}
+
+func normalizeQuery(query url.Values, rules Rules) string {
+	keep := make(map[string]bool, len(rules.KeepParams))
+	for _, param := range rules.KeepParams {
+		keep[strings.ToLower(param)] = true
+	}
+
+	strip := append(append([]string{}, DefaultTrackingParams...), rules.StripParams...)
+	// AMP switches that render the same article
+	strip = append(strip, "amp", "outputtype", "usqp")
+
+	for name, values := range query {
+		lower := strings.ToLower(name)
+		if keep[lower] {
+			continue
+		}
+		if lower == "outputtype" && (len(values) == 0 || !strings.EqualFold(values[0], "amp")) {
+			continue
+		}
+		if matchesAny(lower, strip) {
+			query.Del(name)
+		}
+	}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonicalizer normalizes urls and, when the rules ask for it, follows rel=canonical links
+type Canonicalizer struct {
+	rules  Rules
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func NewCanonicalizer(rules Rules) *Canonicalizer {
+	return &Canonicalizer{
+		rules:  rules,
+		client: &http.Client{Timeout: 15 * time.Second},
+		cache:  make(map[string]string),
+	}
+}
+
+// rulesRevision is bumped whenever a change to Normalize gives a url a different canonical form
+const rulesRevision = 2
+
+// Version identifies the canonical forms these rules give, urls hashed under another version may hash
+// differently now
+func (c *Canonicalizer) Version() string {
+	return fmt.Sprintf("%d|strip=%s|keep=%s|fragment=%t", rulesRevision,
+		strings.Join(c.rules.StripParams, ","), strings.Join(c.rules.KeepParams, ","), c.rules.KeepFragment)
+}
+
+// Normalize applies the rules without touching the network
+func (c *Canonicalizer) Normalize(u string) string {
+	normalized, err := Normalize(u, c.rules)
+	if err != nil {
+		return u
+	}
+	return normalized
+}
+
+// Canonical returns the normalized rel=canonical url of the page, or its own normalized url
+// when following is disabled or the page declares none. Lookups are cached for the process lifetime.
+func (c *Canonicalizer) Canonical(ctx context.Context, u string) string {
+	normalized := c.Normalize(u)
+	if !c.rules.FollowRelCanonical {
+		return normalized
+	}
+
+	c.mu.Lock()
+	cached, ok := c.cache[normalized]
+	c.mu.Unlock()
+	if ok {
+		return cached
+	}
+
+	canonical := normalized
+	if link, err := c.fetchRelCanonical(ctx, u); err == nil && link != "" {
+		if resolved, err := Normalize(link, c.rules); err == nil {
+			canonical = resolved
+		}
+	}
+
+	c.mu.Lock()
+	c.cache[normalized] = canonical
+	c.mu.Unlock()
+	return canonical
+}
+
+func (c *Canonicalizer) fetchRelCanonical(ctx context.Context, u string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// The canonical link lives in <head>, no need to read whole pages
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, 512*1024))
+	if err != nil {
+		return "", err
+	}
+	href, ok := doc.Find(`link[rel="canonical"]`).First().Attr("href")
+	if !ok || strings.TrimSpace(href) == "" {
+		return "", nil
+	}
+
+	link, err := resp.Request.URL.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", err
+	}
+	return link.String(), nil
+}
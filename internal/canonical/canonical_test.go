@@ -0,0 +1,74 @@
+package canonical
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"http://Example.com/post/", "https://example.com/post"},
+		{"https://www.example.com:443/post?utm_source=x&utm_medium=y", "https://example.com/post"},
+		{"https://example.com/post?b=2&a=1&fbclid=abc#comments", "https://example.com/post?a=1&b=2"},
+		{"https://example.com/app#!/route", "https://example.com/app#!/route"},
+		{"https://m.example.com/post", "https://example.com/post"},
+		{"https://example.com/post/amp/", "https://example.com/post"},
+		{"https://example.com/post.amp.html", "https://example.com/post.html"},
+		{"https://example.com/post?outputType=amp", "https://example.com/post"},
+		{"https://www.google.com/amp/s/example.com/post/amp", "https://example.com/post"},
+		{"https://example-com.cdn.ampproject.org/c/s/example.com/post", "https://example.com/post"},
+		{"http://example.com:8080/", "https://example.com:8080"},
+		{"https://m.com/post", "https://m.com/post"},
+		{"https://example.com/amp/", "https://example.com/amp"},
+		{"https://github.com/o/r/blob/main/README.md?ref=v2&source=x&si=1", "https://github.com/o/r/blob/main/README.md?ref=v2&si=1&source=x"},
+	}
+
+	for _, tt := range tests {
+		got, err := Normalize(tt.in, Rules{})
+		if err != nil {
+			t.Errorf("Normalize(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeRules(t *testing.T) {
+	rules := Rules{StripParams: []string{"session*"}, KeepParams: []string{"ref"}, KeepFragment: true}
+	got, err := Normalize("https://example.com/p?ref=home&sessionid=1#part-2", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://example.com/p?ref=home#part-2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := Normalize("ftp://example.com/file", Rules{}); err == nil {
+		t.Error("expected error for non web url")
+	}
+}
+
+func TestCanonicalFollowsRelCanonical(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><link rel="canonical" href="https://original.example/story/?utm_campaign=feed"></head><body></body></html>`)
+	}))
+	defer server.Close()
+
+	c := NewCanonicalizer(Rules{FollowRelCanonical: true})
+	if got, want := c.Canonical(context.Background(), server.URL+"/syndicated"), "https://original.example/story"; got != want {
+		t.Errorf("Canonical = %q, want %q", got, want)
+	}
+
+	plain := NewCanonicalizer(Rules{})
+	if got := plain.Canonical(context.Background(), server.URL+"/syndicated"); got != plain.Normalize(server.URL+"/syndicated") {
+		t.Errorf("Canonical without following = %q", got)
+	}
+}
@@ -1,68 +1,151 @@
 package classifier
 
 import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
 
-	"slices"
-
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/ryan-gang/kindle-send-daemon/internal/linkfile"
 	"github.com/ryan-gang/kindle-send-daemon/internal/types"
+	"golang.org/x/net/html/charset"
 )
 
-func isUrl(u string) bool {
-	for _, proto := range []string{"http://", "https://"} {
-		if strings.HasPrefix(u, proto) {
+// Rejection is an argument that can't be sent, with the reason why
+type Rejection struct {
+	Arg string
+	Err error
+}
+
+// bookMIMEs are the ebooks sent as they are, azw3 books are detected as mobi
+var bookMIMEs = []string{"application/epub+zip", "application/x-mobipocket-ebook", "application/pdf"}
+
+// kindleOnlyMIME are mobi and azw3 books, which Send-to-Kindle mail rejects. They can still be downloaded.
+const kindleOnlyMIME = "application/x-mobipocket-ebook"
+
+// classifyLink checks a web link given as an argument
+func classifyLink(arg string) (types.FileType, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("only http and https links can be sent, not %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", errors.New("the link has no host")
+	}
+	return types.TypeUrl, nil
+}
+
+// classifyFile decides what to do with a local file from its content. Ebooks are sent as they are, files of
+// links become a book of the pages they link to and documents are converted into a book.
+// When mail is set, books Send-to-Kindle doesn't accept are refused.
+func classifyFile(path string, mail bool) (types.FileType, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", errors.New("no such file, web links start with http:// or https://")
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", errors.New("folders can't be sent, name the files in it instead")
+	}
+
+	detected, err := mimetype.DetectFile(path)
+	if err != nil {
+		return "", err
+	}
+	if mail && detected.Is(kindleOnlyMIME) {
+		return "", errors.New("Send-to-Kindle doesn't accept mobi or azw3 books, send epub or pdf, or copy the book over USB")
+	}
+	for _, book := range bookMIMEs {
+		if detected.Is(book) {
+			return types.TypeFile, nil
+		}
+	}
+	if !isText(detected) {
+		return "", fmt.Errorf("%s files are not supported", detected)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if kind, links := linkfile.Parse(data); kind != "" {
+		if len(links) == 0 {
+			return "", fmt.Errorf("%s without any web links", kind)
+		}
+		return types.TypeUrlFile, nil
+	}
+	switch {
+	case detected.Is("text/html"), detected.Is("text/xml") && isXHTML(data):
+		return types.TypeLocalDocument, nil
+	case detected.Is("text/plain"):
+		// Markdown has nothing to sniff, it is told apart from plain text by its extension when converted
+		return types.TypeLocalDocument, nil
+	}
+	return "", fmt.Errorf("%s files are not supported, only documents, ebooks and bookmark exports are", detected)
+}
+
+// isText reports whether the detected type is text of some kind, HTML, XML and JSON included
+func isText(detected *mimetype.MIME) bool {
+	for m := detected; m != nil; m = m.Parent() {
+		if m.Is("text/plain") {
 			return true
 		}
 	}
 	return false
 }
 
-func isUrlFile(u string) bool {
-	file, err := os.Open(u)
-	if err != nil {
-		return false
-	}
-	defer file.Close()
-	buf := make([]byte, 1024)
-	n, _ := file.Read(buf)
-	content := string(buf[:n])
-	lines := strings.SplitSeq(content, "\n")
-	for line := range lines {
-		line = strings.Trim(line, " ")
-		if len(line) == 0 {
-			continue
-		}
-		if !strings.HasPrefix(line, "http") {
+// isXHTML reports whether an XML document is a saved page
+func isXHTML(data []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	for {
+		token, err := decoder.Token()
+		if err != nil {
 			return false
 		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "html"
+		}
 	}
-	return true
 }
 
-func isBook(u string) bool {
-	extension := filepath.Ext(u)
-	// does file exist
-	_, err := os.Stat(u)
-	if err != nil {
-		return false
-	}
-	return slices.Contains([]string{".mobi", ".pdf", ".epub", ".azw3", ".txt"}, extension)
+// Classify turns every argument into a request, arguments that can't be sent are returned with the reason
+func Classify(args []string) ([]types.Request, []Rejection) {
+	return classify(args, false)
 }
 
-func Classify(args []string) []types.Request {
+// ClassifyMail is Classify for requests that end up mailed to Send-to-Kindle, it also refuses mobi and azw3 books
+func ClassifyMail(args []string) ([]types.Request, []Rejection) {
+	return classify(args, true)
+}
+
+func classify(args []string, mail bool) ([]types.Request, []Rejection) {
 	var requests []types.Request
+	var rejected []Rejection
 	for _, arg := range args {
-		if isUrl(arg) {
-			requests = append(requests, types.NewRequest(arg, types.TypeUrl, nil))
-		} else if isUrlFile(arg) {
-			requests = append(requests, types.NewRequest(arg, types.TypeUrlFile, nil))
-		} else if isBook(arg) {
-			requests = append(requests, types.NewRequest(arg, types.TypeFile, nil))
+		var fileType types.FileType
+		var err error
+		if _, statErr := os.Stat(arg); statErr != nil && strings.Contains(arg, "://") {
+			fileType, err = classifyLink(arg)
+		} else {
+			fileType, err = classifyFile(arg, mail)
 		}
+		if err != nil {
+			rejected = append(rejected, Rejection{Arg: arg, Err: err})
+			continue
+		}
+		requests = append(requests, types.NewRequest(arg, fileType, nil))
 	}
 
-	return requests
-
+	return requests, rejected
 }
@@ -0,0 +1,115 @@
+package classifier
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
+)
+
+func writeEpub(t *testing.T, path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	entry, err := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Write([]byte("application/epub+zip"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// Named like a book, the content decides
+		"book.epub":     "",
+		"paper.pdf":     "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<<>>\nendobj\n",
+		"links.txt":     "# To read\nhttps://example.com/a\nA title https://example.com/b\n",
+		"bookmarks.htm": "<!DOCTYPE NETSCAPE-Bookmark-file-1>\n<DL><p>\n<DT><A HREF=\"https://example.com/a\">A</A>\n</DL><p>\n",
+		"saved.html":    "<!DOCTYPE html><html><body><p>A saved page <a href=\"https://example.com\">link</a></p></body></html>",
+		"notes":         "Some thoughts, without an extension.\n",
+		"notes.md":      "# Notes\n\nSome *thoughts*.\n",
+		"empty.opml":    "<?xml version=\"1.0\"?><opml><body><outline text=\"nothing\"/></body></opml>",
+		"image.epub":    "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x02\x00\x00\x00",
+		"data.json":     `{"name": "not bookmarks"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEpub(t, filepath.Join(dir, "book.epub"))
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	args := []string{
+		"https://example.com/post",
+		path("book.epub"),
+		path("paper.pdf"),
+		path("links.txt"),
+		path("bookmarks.htm"),
+		path("saved.html"),
+		path("notes"),
+		path("notes.md"),
+	}
+	want := []types.FileType{
+		types.TypeUrl, types.TypeFile, types.TypeFile, types.TypeUrlFile, types.TypeUrlFile,
+		types.TypeLocalDocument, types.TypeLocalDocument, types.TypeLocalDocument,
+	}
+	rejectedArgs := []string{
+		"ftp://example.com/file",
+		"https://",
+		path("missing.epub"),
+		dir,
+		path("empty.opml"),
+		path("image.epub"),
+		path("data.json"),
+	}
+
+	requests, rejected := Classify(append(args, rejectedArgs...))
+	if len(requests) != len(args) {
+		t.Fatalf("got %d requests, want %d: %+v", len(requests), len(args), requests)
+	}
+	for i, req := range requests {
+		if req.Path != args[i] || req.Type != want[i] {
+			t.Errorf("%s was classified as %s, want %s", req.Path, req.Type, want[i])
+		}
+	}
+	if len(rejected) != len(rejectedArgs) {
+		t.Fatalf("got %d rejections, want %d: %+v", len(rejected), len(rejectedArgs), rejected)
+	}
+	for i, r := range rejected {
+		if r.Arg != rejectedArgs[i] || r.Err == nil {
+			t.Errorf("rejection %d is %+v, want %s with a reason", i, r, rejectedArgs[i])
+		}
+	}
+}
+
+func TestClassifyMail(t *testing.T) {
+	dir := t.TempDir()
+	// Mobi and azw3 books carry their type at offset 60 of the PalmDB header
+	mobi := filepath.Join(dir, "book.azw3")
+	if err := os.WriteFile(mobi, append(make([]byte, 60), "BOOKMOBI"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	epub := filepath.Join(dir, "book.epub")
+	writeEpub(t, epub)
+
+	if requests, rejected := Classify([]string{mobi}); len(requests) != 1 || len(rejected) != 0 {
+		t.Errorf("Classify(azw3) = %+v, %+v, want a request to download", requests, rejected)
+	}
+	requests, rejected := ClassifyMail([]string{mobi, epub})
+	if len(requests) != 1 || requests[0].Path != epub {
+		t.Errorf("ClassifyMail requests %+v, want only the epub", requests)
+	}
+	if len(rejected) != 1 || rejected[0].Arg != mobi || rejected[0].Err == nil {
+		t.Errorf("ClassifyMail rejected %+v, want the azw3 book with a reason", rejected)
+	}
+}
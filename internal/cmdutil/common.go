@@ -1,9 +1,13 @@
 package cmdutil
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/ryan-gang/kindle-send-daemon/internal/classifier"
 	"github.com/ryan-gang/kindle-send-daemon/internal/config"
+	"github.com/ryan-gang/kindle-send-daemon/internal/epubgen"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
 	"github.com/ryan-gang/kindle-send-daemon/internal/util"
 	"github.com/spf13/cobra"
 )
@@ -36,3 +40,48 @@ func CheckDaemonEnabledOrExit(cfg config.ConfigProvider) {
 		os.Exit(1)
 	}
 }
+
+// AddFormatFlag adds the --format flag choosing one of formats for the generated files
+func AddFormatFlag(cmd *cobra.Command, formats []epubgen.Format) {
+	cmd.Flags().StringP("format", "f", "", "Output format of generated files: "+epubgen.FormatNames(formats)+" (default from config, else epub)")
+}
+
+// ApplyFormatFlag sets the format given with --format on every request, after checking it is one of formats.
+// Requests keep the configured default when the flag is not set, which has to be one of formats too.
+func ApplyFormatFlag(cmd *cobra.Command, cfg config.ConfigProvider, requests []types.Request, formats []epubgen.Format) error {
+	name, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		format, err := epubgen.ParseFormat(cfg.GetOutputFormat())
+		if err != nil {
+			return fmt.Errorf("output_format: %w", err)
+		}
+		if err := epubgen.CheckFormat(format, formats); err != nil {
+			return fmt.Errorf("output_format: %w", err)
+		}
+		return nil
+	}
+	format, err := epubgen.ParseFormat(name)
+	if err != nil {
+		return err
+	}
+	if err := epubgen.CheckFormat(format, formats); err != nil {
+		return err
+	}
+	for i := range requests {
+		if requests[i].Options == nil {
+			requests[i].Options = make(map[string]string)
+		}
+		requests[i].Options[types.OptionFormat] = name
+	}
+	return nil
+}
+
+// ReportRejected prints why each rejected argument is left out
+func ReportRejected(rejected []classifier.Rejection) {
+	for _, r := range rejected {
+		util.Red.Printf("SKIPPING %s : %s\n", r.Arg, r.Err)
+	}
+}
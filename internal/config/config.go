@@ -3,12 +3,18 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"image/color"
 	"os"
 	"os/user"
 	"path"
 	"strconv"
 	"strings"
 
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+	"github.com/ryan-gang/kindle-send-daemon/internal/canonical"
+	"github.com/ryan-gang/kindle-send-daemon/internal/cover"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+	"github.com/ryan-gang/kindle-send-daemon/internal/simhash"
 	"github.com/ryan-gang/kindle-send-daemon/internal/util"
 )
 
@@ -25,9 +31,180 @@ type config struct {
 	DaemonEnabled bool   `json:"daemon_enabled"`
 	LogPath       string `json:"log_path"`
 	PidFile       string `json:"pid_file"`
+
+	RetryMaxAttempts      int `json:"retry_max_attempts"`
+	RetryBaseDelayMinutes int `json:"retry_base_delay_minutes"`
+
+	// HistoryTTLDays prunes processed bookmarks after this many days, 0 keeps them forever.
+	// A pruned bookmark that is still listed by a provider is sent again.
+	HistoryTTLDays int `json:"history_ttl_days,omitempty"`
+
+	Canonicalization CanonicalConfig `json:"canonicalization"`
+
+	// Articles within DuplicateThreshold bits of one sent in the last DuplicateWindowDays are skipped,
+	// a negative window turns the check off.
+	DuplicateWindowDays int `json:"duplicate_window_days"`
+	DuplicateThreshold  int `json:"duplicate_threshold"`
+
+	// FetchConcurrency pages are downloaded at once, with at least FetchHostDelayMs between
+	// requests to the same host. A negative delay removes the per host limit.
+	FetchConcurrency int `json:"fetch_concurrency"`
+	FetchHostDelayMs int `json:"fetch_host_delay_ms"`
+
+	// ImageConcurrency images are downloaded at once, images larger than MaxImageKB are left out
+	ImageConcurrency int `json:"image_concurrency"`
+	MaxImageKB       int `json:"max_image_kb"`
+
+	ImageProfile ImageProfileConfig `json:"image_profile"`
+
+	Cover CoverConfig `json:"cover"`
+
+	// OutputFormat is the format books are written in unless a command asks for another, epub when empty
+	OutputFormat string `json:"output_format,omitempty"`
+
+	// Extractors are CSS selector rules for sites the generic article extraction gets wrong
+	Extractors []ExtractorRule `json:"extractors,omitempty"`
+
+	// LinkFootnotes lists the targets of links leaving the book as numbered footnotes at the end of each chapter
+	LinkFootnotes bool `json:"link_footnotes,omitempty"`
+
+	// Providers lists every bookmark source the daemon reads. When empty, the
+	// bookmark_path, raindrop_* and safari_* shorthands below are used instead.
+	Providers []bookmarks.ProviderConfig `json:"providers,omitempty"`
+
+	RaindropToken        string `json:"raindrop_token,omitempty"`
+	RaindropCollectionID int    `json:"raindrop_collection_id,omitempty"`
+
+	SafariBookmarksPath string `json:"safari_bookmarks_path,omitempty"`
+	SafariFolder        string `json:"safari_folder,omitempty"`
+
+	// ConfigDir is the folder the config was loaded from, files placed there override bundled defaults
+	ConfigDir string `json:"-"`
+}
+
+// CanonicalConfig tunes how bookmark urls are normalized before checking whether they were already sent
+type CanonicalConfig struct {
+	StripParams        []string `json:"strip_params,omitempty"`
+	KeepParams         []string `json:"keep_params,omitempty"`
+	KeepFragment       bool     `json:"keep_fragment,omitempty"`
+	FollowRelCanonical bool     `json:"follow_rel_canonical,omitempty"`
+}
+
+// Rules converts the configuration into canonicalization rules
+func (c CanonicalConfig) Rules() canonical.Rules {
+	return canonical.Rules{
+		StripParams:        c.StripParams,
+		KeepParams:         c.KeepParams,
+		KeepFragment:       c.KeepFragment,
+		FollowRelCanonical: c.FollowRelCanonical,
+	}
+}
+
+// ImageProfileConfig picks the device images are prepared for and optionally overrides parts of its preset
+type ImageProfileConfig struct {
+	Device       string `json:"device,omitempty"`
+	MaxWidth     int    `json:"max_width,omitempty"`
+	MaxHeight    int    `json:"max_height,omitempty"`
+	Grayscale    *bool  `json:"grayscale,omitempty"`
+	Dither       *bool  `json:"dither,omitempty"`
+	BookBudgetKB int    `json:"book_budget_kb,omitempty"`
+	// MarginMM overrides the margin of PDF pages
+	MarginMM float64 `json:"margin_mm,omitempty"`
+}
+
+// Profile resolves the device preset and applies the overrides on top of it
+func (p ImageProfileConfig) Profile() imaging.Profile {
+	device := strings.ToLower(p.Device)
+	if device == "" {
+		device = imaging.DefaultProfile
+	}
+	profile, ok := imaging.Profiles[device]
+	if !ok {
+		util.Red.Printf("Warning: unknown device %q in image_profile, using %s\n", p.Device, imaging.DefaultProfile)
+		profile = imaging.Profiles[imaging.DefaultProfile]
+	}
+	return p.override(profile)
+}
+
+// override applies the configured overrides on top of a preset
+func (p ImageProfileConfig) override(profile imaging.Profile) imaging.Profile {
+	if p.MaxWidth > 0 {
+		profile.MaxWidth = p.MaxWidth
+	}
+	if p.MaxHeight > 0 {
+		profile.MaxHeight = p.MaxHeight
+	}
+	if p.Grayscale != nil {
+		profile.Grayscale = *p.Grayscale
+	}
+	if p.Dither != nil {
+		profile.Dither = *p.Dither
+	}
+	if p.BookBudgetKB > 0 {
+		profile.BudgetBytes = int64(p.BookBudgetKB) * 1024
+	}
+	if p.MarginMM > 0 {
+		profile.MarginMM = p.MarginMM
+	}
+	return profile
+}
+
+// ExtractorRule picks the article of pages on Host with a content selector and drops elements matching Remove.
+// "*.example.com" matches example.com and its subdomains.
+type ExtractorRule struct {
+	Host    string   `json:"host"`
+	Content string   `json:"content,omitempty"`
+	Remove  []string `json:"remove,omitempty"`
+}
+
+// CoverConfig lays out the generated cover, which is sized to the image profile's screen
+type CoverConfig struct {
+	Disabled   bool   `json:"disabled,omitempty"`
+	LeadImage  bool   `json:"lead_image,omitempty"`
+	Centered   bool   `json:"centered,omitempty"`
+	Background string `json:"background,omitempty"`
+	Foreground string `json:"foreground,omitempty"`
+	Accent     string `json:"accent,omitempty"`
+}
+
+// Layout applies the configured colors and options on top of the default layout, for a width x height cover
+func (c CoverConfig) Layout(width, height int) cover.Layout {
+	layout := cover.DefaultLayout()
+	if width > 0 && height > 0 {
+		layout.Width, layout.Height = width, height
+	}
+	layout.LeadImage = c.LeadImage
+	layout.Centered = c.Centered
+
+	for _, field := range []struct {
+		value string
+		dst   *color.Color
+	}{
+		{c.Background, &layout.Background},
+		{c.Foreground, &layout.Foreground},
+		{c.Accent, &layout.Accent},
+	} {
+		if field.value == "" {
+			continue
+		}
+		parsed, err := cover.ParseColor(field.value)
+		if err != nil {
+			util.Red.Printf("Warning: %s in cover, using the default\n", err)
+			continue
+		}
+		*field.dst = parsed
+	}
+	return layout
 }
 
 const DefaultTimeout = 120
+const DefaultRetryMaxAttempts = 5
+const DefaultRetryBaseDelayMinutes = 15
+const DefaultDuplicateWindowDays = 30
+const DefaultFetchConcurrency = 4
+const DefaultFetchHostDelayMs = 500
+const DefaultImageConcurrency = 4
+const DefaultMaxImageKB = 5 * 1024
 const XdgConfigHome = "XDG_CONFIG_HOME"
 const ConfigFolderName = "kindle-send"
 
@@ -76,9 +253,78 @@ func SetDaemonDefaults(c *config) error {
 		c.PidFile = path.Join(configDir, "kindle-send.pid")
 	}
 
+	if c.RetryMaxAttempts <= 0 {
+		c.RetryMaxAttempts = DefaultRetryMaxAttempts
+	}
+
+	if c.RetryBaseDelayMinutes <= 0 {
+		c.RetryBaseDelayMinutes = DefaultRetryBaseDelayMinutes
+	}
+
+	if c.DuplicateWindowDays == 0 {
+		c.DuplicateWindowDays = DefaultDuplicateWindowDays
+	}
+
+	if c.DuplicateThreshold <= 0 {
+		c.DuplicateThreshold = simhash.DefaultThreshold
+	}
+
+	if c.FetchConcurrency <= 0 {
+		c.FetchConcurrency = DefaultFetchConcurrency
+	}
+
+	if c.FetchHostDelayMs == 0 {
+		c.FetchHostDelayMs = DefaultFetchHostDelayMs
+	}
+
+	if c.ImageConcurrency <= 0 {
+		c.ImageConcurrency = DefaultImageConcurrency
+	}
+
+	if c.MaxImageKB <= 0 {
+		c.MaxImageKB = DefaultMaxImageKB
+	}
+
 	return nil
 }
 
+// providerConfigs returns the configured providers, falling back to the single source shorthands
+func (c *config) providerConfigs() []bookmarks.ProviderConfig {
+	if len(c.Providers) > 0 {
+		return c.Providers
+	}
+
+	var legacy []bookmarks.ProviderConfig
+	if c.BookmarkPath != "" {
+		legacy = append(legacy, bookmarks.ProviderConfig{
+			Name:     "file",
+			Enabled:  true,
+			Settings: map[string]interface{}{"path": c.BookmarkPath},
+		})
+	}
+	if c.RaindropToken != "" {
+		legacy = append(legacy, bookmarks.ProviderConfig{
+			Name:    "raindrop",
+			Enabled: true,
+			Settings: map[string]interface{}{
+				"token":         c.RaindropToken,
+				"collection_id": c.RaindropCollectionID,
+			},
+		})
+	}
+	if c.SafariBookmarksPath != "" {
+		legacy = append(legacy, bookmarks.ProviderConfig{
+			Name:    "safari",
+			Enabled: true,
+			Settings: map[string]interface{}{
+				"path":   c.SafariBookmarksPath,
+				"folder": c.SafariFolder,
+			},
+		})
+	}
+	return legacy
+}
+
 func exists(filename string) bool {
 	if _, err := os.Stat(filename); err != nil {
 		util.Red.Println(err)
@@ -97,6 +343,14 @@ func NewConfig() *config {
 	config.BookmarkPath = ""
 	config.LogPath = ""
 	config.PidFile = ""
+	config.RetryMaxAttempts = DefaultRetryMaxAttempts
+	config.RetryBaseDelayMinutes = DefaultRetryBaseDelayMinutes
+	config.DuplicateWindowDays = DefaultDuplicateWindowDays
+	config.DuplicateThreshold = simhash.DefaultThreshold
+	config.FetchConcurrency = DefaultFetchConcurrency
+	config.FetchHostDelayMs = DefaultFetchHostDelayMs
+	config.ImageConcurrency = DefaultImageConcurrency
+	config.MaxImageKB = DefaultMaxImageKB
 	return &config
 }
 
@@ -204,6 +458,7 @@ func Load(filename string) (config, error) {
 		return config{}, fmt.Errorf("error decrypting password: %w", err)
 	}
 	c.Password = decryptedPass
+	c.ConfigDir = path.Dir(filename)
 
 	if err := SetDaemonDefaults(&c); err != nil {
 		util.Red.Println("Error setting daemon defaults: ", err)
@@ -1,9 +1,15 @@
 package config
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 	"testing"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
 )
 
 func TestLoad(t *testing.T) {
@@ -12,3 +18,142 @@ func TestLoad(t *testing.T) {
 		return
 	}
 }
+
+// stubProvider records the settings it was configured with
+type stubProvider struct {
+	name     string
+	settings map[string]interface{}
+}
+
+func (p *stubProvider) Name() string { return p.name }
+
+func (p *stubProvider) GetBookmarks(ctx context.Context) ([]bookmarks.Bookmark, error) {
+	return nil, nil
+}
+
+func (p *stubProvider) IsEnabled() bool { return p.settings != nil }
+
+func (p *stubProvider) Configure(settings map[string]interface{}) error {
+	p.settings = settings
+	return nil
+}
+
+// parseConfig reads a config the way Load does, without the checks on the mail settings
+func parseConfig(t *testing.T, data string) config {
+	t.Helper()
+	var c config
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestProvidersBlock(t *testing.T) {
+	c := parseConfig(t, `{
+		"bookmark_path": "/ignored/bookmarks.txt",
+		"providers": [
+			{"name": "raindrop", "enabled": true, "settings": {"token": "abc", "collection_id": 7}},
+			{"name": "work", "type": "file", "enabled": true, "settings": {"path": "/work/links.txt"}},
+			{"name": "safari", "enabled": false, "settings": {"path": "/Bookmarks.plist"}}
+		]
+	}`)
+
+	// The providers block replaces the single source shorthands
+	configs := c.providerConfigs()
+	if len(configs) != 3 {
+		t.Fatalf("%d provider configs, want the 3 of the providers block: %+v", len(configs), configs)
+	}
+
+	types := map[string]string{}
+	registry, err := bookmarks.NewRegistryFromConfig(configs, func(providerType, name string) (bookmarks.Provider, error) {
+		types[name] = providerType
+		return &stubProvider{name: name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"raindrop": "raindrop", "work": "file", "safari": "safari"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("providers created as %v, want %v", types, want)
+	}
+
+	var enabled []string
+	for _, provider := range registry.GetEnabled() {
+		enabled = append(enabled, provider.Name())
+	}
+	if len(enabled) != 2 {
+		t.Errorf("enabled providers %v, want raindrop and work", enabled)
+	}
+	// Disabled entries are registered but never configured
+	safari, found := registry.Get("safari")
+	if !found {
+		t.Fatal("disabled provider was not registered")
+	}
+	if safari.IsEnabled() {
+		t.Error("disabled provider was configured")
+	}
+	work, _ := registry.Get("work")
+	if path := work.(*stubProvider).settings["path"]; path != "/work/links.txt" {
+		t.Errorf("work provider configured with path %v", path)
+	}
+}
+
+func TestProvidersBlockNeedsNames(t *testing.T) {
+	c := parseConfig(t, `{"providers": [{"type": "file", "enabled": true}]}`)
+	_, err := bookmarks.NewRegistryFromConfig(c.providerConfigs(), func(providerType, name string) (bookmarks.Provider, error) {
+		return &stubProvider{name: name}, nil
+	})
+	if err == nil {
+		t.Error("provider entry without a name was accepted")
+	}
+}
+
+func TestLegacyProviderFields(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []bookmarks.ProviderConfig
+	}{
+		{"none", `{}`, nil},
+		{"bookmark file", `{"bookmark_path": "/home/me/bookmarks.txt"}`, []bookmarks.ProviderConfig{
+			{Name: "file", Enabled: true, Settings: map[string]interface{}{"path": "/home/me/bookmarks.txt"}},
+		}},
+		{"every source", `{
+			"bookmark_path": "/home/me/bookmarks.txt",
+			"raindrop_token": "abc",
+			"raindrop_collection_id": 7,
+			"safari_bookmarks_path": "/Bookmarks.plist",
+			"safari_folder": "Kindle"
+		}`, []bookmarks.ProviderConfig{
+			{Name: "file", Enabled: true, Settings: map[string]interface{}{"path": "/home/me/bookmarks.txt"}},
+			{Name: "raindrop", Enabled: true, Settings: map[string]interface{}{"token": "abc", "collection_id": 7}},
+			{Name: "safari", Enabled: true, Settings: map[string]interface{}{"path": "/Bookmarks.plist", "folder": "Kindle"}},
+		}},
+		{"raindrop without a token", `{"raindrop_collection_id": 7}`, nil},
+	}
+	for _, tt := range tests {
+		c := parseConfig(t, tt.data)
+		if got := c.providerConfigs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: provider configs %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImageProfile(t *testing.T) {
+	if got := parseConfig(t, `{}`).ImageProfile.Profile(); got != imaging.Profiles[imaging.DefaultProfile] {
+		t.Errorf("default profile %+v, want the %s preset", got, imaging.DefaultProfile)
+	}
+
+	c := parseConfig(t, `{"image_profile": {"device": "Kindle-Scribe", "max_width": 1000, "grayscale": false, "dither": true}}`)
+	want := imaging.Profiles["kindle-scribe"]
+	want.MaxWidth, want.Grayscale, want.Dither = 1000, false, true
+	if got := c.ImageProfile.Profile(); got != want {
+		t.Errorf("profile %+v, want %+v", got, want)
+	}
+
+	// A preset's dithering can be turned off
+	preset := imaging.Profile{MaxWidth: 600, Grayscale: true, Dither: true}
+	c = parseConfig(t, `{"image_profile": {"dither": false}}`)
+	if got := c.ImageProfile.override(preset); got.Dither || !got.Grayscale || got.MaxWidth != 600 {
+		t.Errorf("dither false on %+v gave %+v", preset, got)
+	}
+}
@@ -1,5 +1,10 @@
 package config
 
+import (
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+	"github.com/ryan-gang/kindle-send-daemon/internal/canonical"
+)
+
 // ConfigProvider defines the interface for configuration access
 type ConfigProvider interface {
 	GetSender() string
@@ -13,6 +18,14 @@ type ConfigProvider interface {
 	IsDaemonEnabled() bool
 	GetLogPath() string
 	GetPidFile() string
+	GetRetryMaxAttempts() int
+	GetRetryBaseDelay() int
+	GetHistoryTTL() int
+	GetCanonicalRules() canonical.Rules
+	GetDuplicateWindow() int
+	GetDuplicateThreshold() int
+	GetProviders() []bookmarks.ProviderConfig
+	GetOutputFormat() string
 }
 
 // ConfigImpl implements ConfigProvider interface
@@ -68,3 +81,42 @@ func (c *ConfigImpl) GetLogPath() string {
 func (c *ConfigImpl) GetPidFile() string {
 	return c.cfg.PidFile
 }
+
+func (c *ConfigImpl) GetRetryMaxAttempts() int {
+	return c.cfg.RetryMaxAttempts
+}
+
+// GetRetryBaseDelay returns the delay before the first retry in minutes, it doubles on every further attempt
+func (c *ConfigImpl) GetRetryBaseDelay() int {
+	return c.cfg.RetryBaseDelayMinutes
+}
+
+// GetHistoryTTL returns how many days processed bookmarks are kept, 0 means forever
+func (c *ConfigImpl) GetHistoryTTL() int {
+	return c.cfg.HistoryTTLDays
+}
+
+// GetCanonicalRules returns the url canonicalization rules used for deduplication
+func (c *ConfigImpl) GetCanonicalRules() canonical.Rules {
+	return c.cfg.Canonicalization.Rules()
+}
+
+// GetDuplicateWindow returns how many days back sent articles are compared against, negative disables the check
+func (c *ConfigImpl) GetDuplicateWindow() int {
+	return c.cfg.DuplicateWindowDays
+}
+
+// GetDuplicateThreshold returns how many fingerprint bits near-duplicate articles may differ by
+func (c *ConfigImpl) GetDuplicateThreshold() int {
+	return c.cfg.DuplicateThreshold
+}
+
+// GetProviders returns the bookmark providers the daemon should build its registry from
+func (c *ConfigImpl) GetProviders() []bookmarks.ProviderConfig {
+	return c.cfg.providerConfigs()
+}
+
+// GetOutputFormat returns the configured format of generated books, empty for the default
+func (c *ConfigImpl) GetOutputFormat() string {
+	return c.cfg.OutputFormat
+}
@@ -0,0 +1,249 @@
+package cover
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Default cover size when the device profile leaves it open, the size Amazon recommends for ebook covers
+const (
+	DefaultWidth  = 1600
+	DefaultHeight = 2560
+)
+
+// Info is what the cover tells about the book
+type Info struct {
+	Title    string
+	Domains  []string
+	Date     time.Time
+	Articles int
+}
+
+// Layout controls how the cover is drawn
+type Layout struct {
+	Width, Height int
+	Background    color.Color
+	Foreground    color.Color
+	// Accent colors the rule between the title and the details
+	Accent color.Color
+	// Centered centers the text instead of aligning it to the left margin
+	Centered bool
+	// LeadImage fills the top of the cover with the lead image of the first article, when there is one
+	LeadImage bool
+}
+
+// DefaultLayout is black text on white, left aligned
+func DefaultLayout() Layout {
+	return Layout{
+		Width:      DefaultWidth,
+		Height:     DefaultHeight,
+		Background: color.White,
+		Foreground: color.Black,
+		Accent:     color.Gray{Y: 0x55},
+	}
+}
+
+var (
+	boldFont    = mustParse(gobold.TTF)
+	regularFont = mustParse(goregular.TTF)
+)
+
+func mustParse(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// maxTitleLines is the most lines the title may take before its font is shrunk further
+const maxTitleLines = 6
+
+// Render draws the cover. lead may be nil, it is only used when the layout asks for it.
+func Render(info Info, layout Layout, lead image.Image) *image.RGBA {
+	if layout.Width <= 0 || layout.Height <= 0 {
+		layout.Width, layout.Height = DefaultWidth, DefaultHeight
+	}
+	w, h := layout.Width, layout.Height
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(layout.Background), image.Point{}, draw.Src)
+
+	margin := w / 12
+	textWidth := w - 2*margin
+	top := h / 8
+	if layout.LeadImage && lead != nil {
+		band := image.Rect(0, 0, w, h*2/5)
+		drawCropped(canvas, band, lead)
+		top = band.Max.Y + h/16
+	}
+
+	titleFace, titleLines := fitTitle(info.Title, w, textWidth)
+	defer titleFace.Close()
+
+	y := drawLines(canvas, titleFace, titleLines, layout, margin, textWidth, top)
+
+	// Accent rule between the title and the details
+	y += h / 40
+	ruleWidth := textWidth / 4
+	ruleX := margin
+	if layout.Centered {
+		ruleX = (w - ruleWidth) / 2
+	}
+	draw.Draw(canvas, image.Rect(ruleX, y, ruleX+ruleWidth, y+max(2, h/300)), image.NewUniform(layout.Accent), image.Point{}, draw.Src)
+	y += h / 20
+
+	detailFace := newFace(regularFont, float64(w)/28)
+	defer detailFace.Close()
+	var details []string
+	if domains := domainLine(detailFace, info.Domains, textWidth); domains != "" {
+		details = append(details, domains)
+	}
+	if !info.Date.IsZero() {
+		details = append(details, info.Date.Format("2 January 2006"))
+	}
+	if info.Articles > 1 {
+		details = append(details, fmt.Sprintf("%d articles", info.Articles))
+	}
+	drawLines(canvas, detailFace, details, layout, margin, textWidth, y)
+
+	return canvas
+}
+
+// fitTitle picks the largest font size at which the title fits in maxTitleLines lines
+func fitTitle(title string, canvasWidth, textWidth int) (font.Face, []string) {
+	for size := float64(canvasWidth) / 11; ; size *= 0.9 {
+		face := newFace(boldFont, size)
+		lines := wrap(face, title, textWidth)
+		if len(lines) <= maxTitleLines || size < float64(canvasWidth)/40 {
+			return face, lines
+		}
+		face.Close()
+	}
+}
+
+func newFace(f *opentype.Font, size float64) font.Face {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		panic(err)
+	}
+	return face
+}
+
+// drawLines writes lines one under the other starting at y and returns the y below the last one
+func drawLines(dst draw.Image, face font.Face, lines []string, layout Layout, margin, width, y int) int {
+	metrics := face.Metrics()
+	lineHeight := (metrics.Height * 6 / 5).Ceil()
+	drawer := font.Drawer{Dst: dst, Src: image.NewUniform(layout.Foreground), Face: face}
+	for _, line := range lines {
+		x := margin
+		if layout.Centered {
+			x += (width - drawer.MeasureString(line).Ceil()) / 2
+		}
+		drawer.Dot = fixed.P(x, y+metrics.Ascent.Ceil())
+		drawer.DrawString(line)
+		y += lineHeight
+	}
+	return y
+}
+
+// wrap breaks text into lines no wider than width, words longer than a line are cut
+func wrap(face font.Face, text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if font.MeasureString(face, candidate).Ceil() <= width {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		for font.MeasureString(face, word).Ceil() > width && len([]rune(word)) > 1 {
+			cut := fitRunes(face, word, width)
+			lines = append(lines, word[:cut])
+			word = word[cut:]
+		}
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fitRunes returns the byte length of the longest prefix of word that fits in width, at least one rune
+func fitRunes(face font.Face, word string, width int) int {
+	fit := 0
+	for i, r := range word {
+		end := i + len(string(r))
+		if fit > 0 && font.MeasureString(face, word[:end]).Ceil() > width {
+			break
+		}
+		fit = end
+	}
+	return fit
+}
+
+// domainLine joins as many domains as fit on one line and counts the rest
+func domainLine(face font.Face, domains []string, width int) string {
+	line := ""
+	for i, domain := range domains {
+		candidate := domain
+		if line != "" {
+			candidate = line + " · " + domain
+		}
+		suffix := ""
+		if left := len(domains) - i - 1; left > 0 {
+			suffix = fmt.Sprintf(" +%d more", left)
+		}
+		if line != "" && font.MeasureString(face, candidate+suffix).Ceil() > width {
+			return fmt.Sprintf("%s +%d more", line, len(domains)-i)
+		}
+		line = candidate
+	}
+	return line
+}
+
+// drawCropped scales img to cover r entirely and crops what overflows, keeping the center
+func drawCropped(dst draw.Image, r image.Rectangle, img image.Image) {
+	src := img.Bounds()
+	if src.Empty() {
+		return
+	}
+	scale := max(float64(r.Dx())/float64(src.Dx()), float64(r.Dy())/float64(src.Dy()))
+	cropW, cropH := max(1, int(float64(r.Dx())/scale)), max(1, int(float64(r.Dy())/scale))
+	x0 := src.Min.X + (src.Dx()-cropW)/2
+	y0 := src.Min.Y + (src.Dy()-cropH)/2
+	draw.CatmullRom.Scale(dst, r, img, image.Rect(x0, y0, x0+cropW, y0+cropH), draw.Src, nil)
+}
+
+// ParseColor reads #rgb and #rrggbb colors
+func ParseColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q, want #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q, want #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
@@ -0,0 +1,84 @@
+package cover
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/image/font"
+)
+
+func TestRender(t *testing.T) {
+	layout := DefaultLayout()
+	layout.Width, layout.Height = 600, 800
+	info := Info{
+		Title:    "A rather long title that has to wrap over several lines of the cover",
+		Domains:  []string{"example.com", "blog.example.org"},
+		Date:     time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC),
+		Articles: 3,
+	}
+
+	img := Render(info, layout, nil)
+	if size := img.Bounds().Size(); size != image.Pt(600, 800) {
+		t.Fatalf("cover is %v, want 600x800", size)
+	}
+	if img.RGBAAt(0, 0) != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("background is %v, want white", img.RGBAAt(0, 0))
+	}
+	if dark := darkPixels(img, img.Bounds()); dark == 0 {
+		t.Error("no text was drawn")
+	}
+
+	// The lead image fills the top band
+	red := color.RGBA{200, 0, 0, 255}
+	lead := image.NewRGBA(image.Rect(0, 0, 40, 10))
+	draw.Draw(lead, lead.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	layout.LeadImage = true
+	withLead := Render(info, layout, lead)
+	if got := withLead.RGBAAt(300, 10); got != red {
+		t.Errorf("top of the cover is %v, want the lead image", got)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	face := newFace(regularFont, 20)
+	defer face.Close()
+
+	lines := wrap(face, "one two three four five six seven "+strings.Repeat("x", 80), 150)
+	if len(lines) < 3 {
+		t.Fatalf("got %q, want several lines", lines)
+	}
+	for _, line := range lines {
+		if w := font.MeasureString(face, line).Ceil(); w > 150 {
+			t.Errorf("line %q is %d wide", line, w)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("#1a2B3c")
+	if err != nil || c != (color.RGBA{0x1a, 0x2b, 0x3c, 0xff}) {
+		t.Errorf("got %v, %v", c, err)
+	}
+	if c, err := ParseColor("#fff"); err != nil || c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("got %v, %v", c, err)
+	}
+	if _, err := ParseColor("blue"); err == nil {
+		t.Error("expected an error for a named color")
+	}
+}
+
+func darkPixels(img *image.RGBA, r image.Rectangle) int {
+	dark := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if img.RGBAAt(x, y).R < 128 {
+				dark++
+			}
+		}
+	}
+	return dark
+}
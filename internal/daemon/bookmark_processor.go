@@ -3,29 +3,61 @@ package daemon
 import (
 	"context"
 	"crypto/md5"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"sync"
 	"time"
 
 	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
 	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks/providers"
+	"github.com/ryan-gang/kindle-send-daemon/internal/canonical"
 	"github.com/ryan-gang/kindle-send-daemon/internal/classifier"
 	"github.com/ryan-gang/kindle-send-daemon/internal/config"
 	"github.com/ryan-gang/kindle-send-daemon/internal/handler"
 	"github.com/ryan-gang/kindle-send-daemon/internal/logger"
+	"github.com/ryan-gang/kindle-send-daemon/internal/simhash"
 	"github.com/ryan-gang/kindle-send-daemon/internal/types"
 	"github.com/ryan-gang/kindle-send-daemon/internal/util"
 )
 
+// ProcessedBookmark records the outcome of the latest attempt at a bookmark
 type ProcessedBookmark struct {
 	URL       string    `json:"url"`
 	Hash      string    `json:"hash"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// CanonicalURL is the page's rel=canonical url when it differs from the bookmarked one
+	CanonicalURL  string `json:"canonical_url,omitempty"`
+	CanonicalHash string `json:"canonical_hash,omitempty"`
+
+	// Fingerprint is the SimHash of the article text, used to spot the same article under unrelated urls
+	Fingerprint simhash.Fingerprint `json:"fingerprint,omitempty"`
+
+	// Status is empty for entries written before outcomes were tracked, those were all sent
+	Status   types.Status `json:"status,omitempty"`
+	Source   string       `json:"source,omitempty"`
+	Title    string       `json:"title,omitempty"`
+	EpubPath string       `json:"epub_path,omitempty"`
+	Size     int64        `json:"size,omitempty"`
+	ErrText  string       `json:"error,omitempty"`
+}
+
+// EffectiveStatus fills in the status of entries written before outcomes were tracked
+func (pb ProcessedBookmark) EffectiveStatus() types.Status {
+	if pb.Status == "" {
+		return types.StatusSent
+	}
+	return pb.Status
+}
+
+// Settled reports whether the bookmark needs no further attempts
+func (pb ProcessedBookmark) Settled() bool {
+	status := pb.EffectiveStatus()
+	return status == types.StatusSent || status == types.StatusSkipped
 }
 
+// ProcessedState is the layout of the processed_bookmarks.json file used before the state store
 type ProcessedState struct {
 	Bookmarks []ProcessedBookmark `json:"bookmarks"`
 	LastCheck time.Time           `json:"last_check"`
@@ -35,50 +67,78 @@ type ProcessedState struct {
 
 type BookmarkProcessor struct {
 	statePath string
-	state     ProcessedState
+	storePath string
+	retries   *RetryQueue
+	canonical *canonical.Canonicalizer
 	registry  *bookmarks.Registry
 	cfg       config.ConfigProvider
 	logger    logger.LoggerInterface
-}
 
-func NewBookmarkProcessor(cfg config.ConfigProvider, logger logger.LoggerInterface) (*BookmarkProcessor, error) {
-	statePath := filepath.Join(filepath.Dir(cfg.GetPidFile()), "processed_bookmarks.json")
+	// canonicals holds the rel=canonical link of each pending bookmark by hash, found while reading bookmarks
+	// so recording the outcome never fetches a page
+	canonicals map[string]canonicalLink
+}
 
-	// Create registry and register providers
-	registry := bookmarks.NewRegistry()
+// canonicalLink is the rel=canonical url of a page and its hash, both empty when the page is its own canonical
+type canonicalLink struct {
+	url  string
+	hash string
+}
 
-	// Register file provider
-	fileProvider := providers.NewFileProvider()
-	registry.Register(fileProvider)
+// canonicalWorkers bounds how many pages are fetched at once to read their rel=canonical link
+const canonicalWorkers = 4
 
-	// Configure file provider if bookmark path is set
-	if cfg.GetBookmarkPath() != "" {
-		providerConfig := map[string]interface{}{
-			"path": cfg.GetBookmarkPath(),
-		}
-		fileProvider.Configure(providerConfig)
+func NewBookmarkProcessor(cfg config.ConfigProvider, logger logger.LoggerInterface) (*BookmarkProcessor, error) {
+	// Build one provider per entry of the providers config block
+	registry, err := bookmarks.NewRegistryFromConfig(cfg.GetProviders(), providers.New)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up bookmark providers: %w", err)
 	}
 
-	processor := &BookmarkProcessor{
-		statePath: statePath,
-		state:     ProcessedState{Bookmarks: make([]ProcessedBookmark, 0)},
-		registry:  registry,
+	processor := newStateProcessor(cfg)
+	processor.registry = registry
+	processor.logger = logger
+	processor.loadRetries()
+	return processor, nil
+}
+
+// newStateProcessor locates the state store and retry queue, without any providers or loading anything
+func newStateProcessor(cfg config.ConfigProvider) *BookmarkProcessor {
+	stateDir := filepath.Dir(cfg.GetPidFile())
+	retryPath := filepath.Join(stateDir, "retry_queue.json")
+
+	return &BookmarkProcessor{
+		statePath: filepath.Join(stateDir, "processed_bookmarks.json"),
+		storePath: filepath.Join(stateDir, "processed_bookmarks.db"),
+		retries:   NewRetryQueue(retryPath, cfg.GetRetryMaxAttempts(), time.Duration(cfg.GetRetryBaseDelay())*time.Minute),
+		canonical: canonical.NewCanonicalizer(cfg.GetCanonicalRules()),
 		cfg:       cfg,
-		logger:    logger,
 	}
+}
 
-	processor.loadState()
-	return processor, nil
+// loadRetries reads the retry queue and rekeys entries queued under an older url hash
+func (bp *BookmarkProcessor) loadRetries() {
+	if err := bp.retries.Load(); err != nil {
+		util.Red.Printf("Warning: failed to load retry queue: %v\n", err)
+		return
+	}
+	if bp.retries.Rehash(bp.hashBookmark) {
+		if err := bp.retries.Save(); err != nil {
+			util.Red.Printf("Warning: failed to save retry queue: %v\n", err)
+		}
+	}
 }
 
-func (bp *BookmarkProcessor) ReadBookmarks() ([]string, error) {
-	ctx := context.Background()
+func (bp *BookmarkProcessor) ReadBookmarks(ctx context.Context) ([]bookmarks.Bookmark, error) {
 	providers := bp.registry.GetEnabled()
 
 	if len(providers) == 0 {
 		return nil, fmt.Errorf("no enabled bookmark providers")
 	}
 
+	// Pick up changes made while the daemon slept, eg. by `kindle-send history forget`
+	bp.loadRetries()
+
 	var allBookmarks []bookmarks.Bookmark
 
 	// Collect bookmarks from all providers
@@ -92,140 +152,423 @@ func (bp *BookmarkProcessor) ReadBookmarks() ([]string, error) {
 		allBookmarks = append(allBookmarks, bookmarkList...)
 	}
 
-	// Convert bookmarks to URL strings for backward compatibility
-	var urls []string
-	for _, bookmark := range allBookmarks {
-		urls = append(urls, bookmark.URL)
-	}
+	// Filter out already processed bookmarks, then add failed ones whose backoff has passed
+	bp.canonicals = make(map[string]canonicalLink)
+	var newBookmarks []bookmarks.Bookmark
+	err := bp.withStore(func(store StateStore) error {
+		var err error
+		if newBookmarks, err = bp.filterNewBookmarks(ctx, store, allBookmarks); err != nil {
+			return err
+		}
 
-	// Filter out already processed bookmarks
-	newBookmarks := bp.filterNewBookmarks(urls)
+		due := bp.retries.Due(time.Now())
+		if len(due) == 0 {
+			return nil
+		}
+		bp.logger.Infof("Retrying %d previously failed bookmarks", len(due))
+		for _, bookmark := range due {
+			// The earlier attempt recorded the canonical link, the page isn't fetched again for it
+			hash := bp.hashBookmark(bookmark.URL)
+			processed, found, err := store.Get(hash)
+			if err != nil {
+				return fmt.Errorf("failed to look up %s: %w", bookmark.URL, err)
+			}
+			if found && processed.Hash == hash {
+				bp.canonicals[hash] = canonicalLink{url: processed.CanonicalURL, hash: processed.CanonicalHash}
+			}
+		}
+		newBookmarks = append(newBookmarks, due...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return newBookmarks, nil
 }
 
-func (bp *BookmarkProcessor) filterNewBookmarks(bookmarks []string) []string {
-	var newBookmarks []string
-	processedHashes := make(map[string]bool)
+func (bp *BookmarkProcessor) filterNewBookmarks(ctx context.Context, store StateStore, bookmarkList []bookmarks.Bookmark) ([]bookmarks.Bookmark, error) {
+	var unknown []bookmarks.Bookmark
+	seen := make(map[string]bool)
+
+	for _, bookmark := range bookmarkList {
+		hash := bp.hashBookmark(bookmark.URL)
+		// Same link from several providers is only sent once
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		// Queued bookmarks are picked up by the retry schedule instead
+		if bp.retries.Contains(hash) {
+			continue
+		}
+
+		processed, found, err := store.Get(hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %s: %w", bookmark.URL, err)
+		}
+		if found && processed.Settled() {
+			continue
+		}
+		unknown = append(unknown, bookmark)
+	}
 
-	for _, processed := range bp.state.Bookmarks {
-		processedHashes[processed.Hash] = true
+	// Only unknown links are worth fetching to find the url they were syndicated from
+	links := bp.lookupCanonicals(ctx, unknown)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	for _, bookmark := range bookmarks {
-		hash := bp.hashBookmark(bookmark)
-		if !processedHashes[hash] {
-			newBookmarks = append(newBookmarks, bookmark)
+	var newBookmarks []bookmarks.Bookmark
+	for i, bookmark := range unknown {
+		link := links[i]
+		bp.canonicals[bp.hashBookmark(bookmark.URL)] = link
+		if link.hash != "" {
+			if seen[link.hash] {
+				continue
+			}
+			seen[link.hash] = true
+
+			processed, found, err := store.Get(link.hash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up %s: %w", bookmark.URL, err)
+			}
+			if found && processed.Settled() {
+				bp.logger.Infof("Skipping %s, already sent as %s", bookmark.URL, processed.URL)
+				continue
+			}
 		}
+
+		newBookmarks = append(newBookmarks, bookmark)
 	}
 
-	return newBookmarks
+	return newBookmarks, nil
 }
 
+// lookupCanonicals finds the rel=canonical link of each bookmark, fetching at most canonicalWorkers pages at once.
+// Bookmarks left when ctx is cancelled keep an empty link.
+func (bp *BookmarkProcessor) lookupCanonicals(ctx context.Context, list []bookmarks.Bookmark) []canonicalLink {
+	links := make([]canonicalLink, len(list))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(canonicalWorkers, len(list)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				canonicalURL, hash := bp.relCanonical(ctx, list[i].URL)
+				links[i] = canonicalLink{url: canonicalURL, hash: hash}
+			}
+		}()
+	}
+
+	for i := range list {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return links
+}
+
+// hashBookmark hashes the canonical form of a url, so tracking parameters and mirrors of a page share one entry
 func (bp *BookmarkProcessor) hashBookmark(bookmark string) string {
-	hash := md5.Sum([]byte(bookmark))
-	return fmt.Sprintf("%x", hash)
+	return hashURL(bp.canonical.Normalize(bookmark))
 }
 
-func (bp *BookmarkProcessor) ProcessBookmarks(bookmarks []string) ([]string, error) {
-	if len(bookmarks) == 0 {
-		return []string{}, nil
+// relCanonical returns the rel=canonical url of a bookmark and its hash, both empty when
+// following canonical links is disabled or the page is its own canonical
+func (bp *BookmarkProcessor) relCanonical(ctx context.Context, bookmark string) (string, string) {
+	canonicalURL := bp.canonical.Canonical(ctx, bookmark)
+	hash := hashURL(canonicalURL)
+	if hash == bp.hashBookmark(bookmark) {
+		return "", ""
 	}
+	return canonicalURL, hash
+}
 
-	downloadedRequests, err := bp.downloadBookmarks(bookmarks)
-	if err != nil {
-		return nil, err
+func hashURL(u string) string {
+	hash := md5.Sum([]byte(u))
+	return fmt.Sprintf("%x", hash)
+}
+
+// ProcessBookmarks downloads and mails pending bookmarks. When ctx is cancelled mid-cycle nothing is
+// recorded, so the same bookmarks are picked up again on the next start.
+func (bp *BookmarkProcessor) ProcessBookmarks(ctx context.Context, pending []bookmarks.Bookmark) ([]ProcessedBookmark, error) {
+	if len(pending) == 0 {
+		return []ProcessedBookmark{}, nil
 	}
 
-	if err := bp.sendBookmarksViaEmail(downloadedRequests); err != nil {
+	results := bp.downloadBookmarks(ctx, pending)
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	results = bp.skipDuplicates(pending, results)
+	results = bp.sendBookmarksViaEmail(results)
 
-	processedBookmarks := bp.updateProcessedState(bookmarks)
-	bp.cleanupOldBookmarks()
+	now := time.Now()
+	processed := make([]ProcessedBookmark, 0, len(pending))
+	sent, failed := 0, 0
+	for i, bookmark := range pending {
+		result := results[i]
+		switch result.Status {
+		case types.StatusDownloadFailed:
+			bp.recordFailure(bookmark, StageDownload, result.Err)
+			failed++
+		case types.StatusMailFailed:
+			bp.recordFailure(bookmark, StageMail, result.Err)
+			failed++
+		case types.StatusSent:
+			sent++
+		}
+		processed = append(processed, bp.processedEntry(bookmark, result, now))
+	}
 
-	if err := bp.saveState(); err != nil {
+	err := bp.withStore(func(store StateStore) error {
+		if err := store.Commit(now, processed...); err != nil {
+			return err
+		}
+		return bp.pruneHistory(store, now)
+	})
+	if err != nil {
+		bp.logger.Errorf("Failed to save processed state: %v", err)
 		util.Red.Printf("Warning: failed to save processed state: %v\n", err)
 	}
+	if err := bp.retries.Save(); err != nil {
+		util.Red.Printf("Warning: failed to save retry queue: %v\n", err)
+	}
 
-	return processedBookmarks, nil
+	if sent == 0 && failed > 0 {
+		return processed, fmt.Errorf("no bookmarks were sent, %d failures are queued for retry", failed)
+	}
+
+	return processed, nil
 }
 
-func (bp *BookmarkProcessor) downloadBookmarks(bookmarks []string) ([]types.Request, error) {
-	downloadRequests := classifier.Classify(bookmarks)
-	if len(downloadRequests) == 0 {
-		bp.logger.Info("No valid bookmarks to process")
-		util.Cyan.Println("No valid bookmarks to process")
-		return nil, fmt.Errorf("no valid bookmarks to process")
-	}
+// downloadBookmarks converts each bookmark on its own, returning one result per bookmark in the same order
+func (bp *BookmarkProcessor) downloadBookmarks(ctx context.Context, pending []bookmarks.Bookmark) []types.Result {
+	results := make([]types.Result, 0, len(pending))
+	downloaded := 0
+
+	for _, bookmark := range pending {
+		downloadRequests, rejected := classifier.ClassifyMail([]string{bookmark.URL})
+		if len(rejected) > 0 {
+			bp.logger.Infof("Skipping %s, %v", bookmark.URL, rejected[0].Err)
+			results = append(results, types.Result{
+				Request: types.NewRequest(bookmark.URL, types.TypeUrl, nil),
+				Status:  types.StatusSkipped,
+				Err:     rejected[0].Err,
+			})
+			continue
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
 
-	bp.logger.Infof("Classified %d bookmarks for processing", len(downloadRequests))
+		result := handler.Queue(ctx, downloadRequests)[0]
+		if result.Status == types.StatusDownloaded {
+			downloaded++
+		}
+		results = append(results, result)
+	}
 
-	downloadedRequests := handler.Queue(downloadRequests)
-	if len(downloadedRequests) == 0 {
+	if downloaded == 0 {
 		bp.logger.Warn("No bookmarks were successfully downloaded")
 		util.Cyan.Println("No bookmarks were successfully downloaded")
-		return nil, fmt.Errorf("no bookmarks were successfully downloaded")
+	} else {
+		bp.logger.Infof("Successfully downloaded %d bookmarks", downloaded)
+	}
+	return results
+}
+
+// skipDuplicates marks downloaded articles whose text is a near-duplicate of one sent within the
+// configured window, or of an earlier article in the same batch, as skipped so they are not mailed
+func (bp *BookmarkProcessor) skipDuplicates(pending []bookmarks.Bookmark, results []types.Result) []types.Result {
+	windowDays := bp.cfg.GetDuplicateWindow()
+	if windowDays < 0 {
+		return results
 	}
+	threshold := bp.cfg.GetDuplicateThreshold()
+	since := time.Now().AddDate(0, 0, -windowDays)
+
+	var sent []ProcessedBookmark
+	err := bp.withStore(func(store StateStore) error {
+		return store.ForEachSince(since, func(bookmark ProcessedBookmark) error {
+			if bookmark.Fingerprint != 0 && bookmark.EffectiveStatus() == types.StatusSent {
+				sent = append(sent, bookmark)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		// Sending a duplicate beats not sending at all
+		bp.logger.Warnf("Failed to read sent articles, not checking for duplicates: %v", err)
+		return results
+	}
+
+	for i, result := range results {
+		if result.Status != types.StatusDownloaded || result.Fingerprint == 0 {
+			continue
+		}
+
+		var original string
+		for _, bookmark := range sent {
+			if result.Fingerprint.Near(bookmark.Fingerprint, threshold) {
+				original = bookmark.URL
+				break
+			}
+		}
+		for j := 0; j < i && original == ""; j++ {
+			if results[j].Status == types.StatusDownloaded && result.Fingerprint.Near(results[j].Fingerprint, threshold) {
+				original = pending[j].URL
+			}
+		}
+		if original == "" {
+			continue
+		}
+
+		bp.logger.Infof("Skipping %s, it is a near-duplicate of %s", pending[i].URL, original)
+		util.Magenta.Printf("SKIPPING %s, near-duplicate of %s\n", pending[i].URL, original)
+		results[i].Status = types.StatusSkipped
+		results[i].Err = fmt.Errorf("near-duplicate of %s", original)
 
-	bp.logger.Infof("Successfully downloaded %d bookmarks", len(downloadedRequests))
-	return downloadedRequests, nil
+		// The book is never mailed, so it doesn't stay in the store directory
+		if err := os.Remove(result.Path); err != nil && !os.IsNotExist(err) {
+			bp.logger.Warnf("Failed to remove %s: %v", result.Path, err)
+		}
+		results[i].Path = ""
+	}
+	return results
 }
 
-func (bp *BookmarkProcessor) sendBookmarksViaEmail(downloadedRequests []types.Request) error {
+func (bp *BookmarkProcessor) sendBookmarksViaEmail(results []types.Result) []types.Result {
 	timeout := bp.cfg.GetCheckInterval() * 60
 	if timeout < 60 {
 		timeout = config.DefaultTimeout
 	}
 
-	bp.logger.Infof("Sending %d bookmarks via email with timeout %d seconds", len(downloadedRequests), timeout)
-	handler.Mail(downloadedRequests, timeout)
-	return nil
+	bp.logger.Infof("Sending downloaded bookmarks via email with timeout %d seconds", timeout)
+	return handler.Mail(results, timeout)
 }
 
-func (bp *BookmarkProcessor) updateProcessedState(bookmarks []string) []string {
-	var processedBookmarks []string
-	now := time.Now()
+// recordFailure queues a failed bookmark for a later cycle
+func (bp *BookmarkProcessor) recordFailure(bookmark bookmarks.Bookmark, stage string, cause error) {
+	entry := bp.retries.RecordFailure(bookmark, bp.hashBookmark(bookmark.URL), stage, cause, time.Now())
+	if entry.Dead {
+		bp.logger.Errorf("Giving up on %s after %d attempts, last %s error: %v", bookmark.URL, entry.Attempts, stage, cause)
+		util.Red.Printf("Giving up on %s after %d attempts\n", bookmark.URL, entry.Attempts)
+		return
+	}
+	bp.logger.Warnf("Failed to %s %s (attempt %d), retrying after %s", stage, bookmark.URL, entry.Attempts, entry.NextAttempt.Format(time.RFC3339))
+}
 
-	for _, bookmark := range bookmarks {
-		hash := bp.hashBookmark(bookmark)
-		bp.state.Bookmarks = append(bp.state.Bookmarks, ProcessedBookmark{
-			URL:       bookmark,
-			Hash:      hash,
-			Timestamp: now,
-		})
-		processedBookmarks = append(processedBookmarks, bookmark)
+// processedEntry builds the state entry for the outcome of a bookmark, it replaces the entry of an earlier attempt
+func (bp *BookmarkProcessor) processedEntry(bookmark bookmarks.Bookmark, result types.Result, now time.Time) ProcessedBookmark {
+	hash := bp.hashBookmark(bookmark.URL)
+	if result.Status == types.StatusSent || result.Status == types.StatusSkipped {
+		bp.retries.Remove(hash)
 	}
 
-	bp.state.LastCheck = now
-	return processedBookmarks
+	title := result.Title
+	if title == "" {
+		title = bookmark.Title
+	}
+	link := bp.canonicals[hash]
+	return ProcessedBookmark{
+		URL:           bookmark.URL,
+		Hash:          hash,
+		Timestamp:     now,
+		CanonicalURL:  link.url,
+		CanonicalHash: link.hash,
+		Status:        result.Status,
+		Source:        bookmark.Source,
+		Title:         title,
+		EpubPath:      result.Path,
+		Size:          result.Size,
+		Fingerprint:   result.Fingerprint,
+		ErrText:       result.ErrText(),
+	}
 }
 
-func (bp *BookmarkProcessor) cleanupOldBookmarks() {
-	if len(bp.state.Bookmarks) > 1000 {
-		sort.Slice(bp.state.Bookmarks, func(i, j int) bool {
-			return bp.state.Bookmarks[i].Timestamp.After(bp.state.Bookmarks[j].Timestamp)
-		})
-		bp.state.Bookmarks = bp.state.Bookmarks[:1000]
+// pruneHistory drops entries older than the configured history TTL, history is kept forever without one
+func (bp *BookmarkProcessor) pruneHistory(store StateStore, now time.Time) error {
+	ttlDays := bp.cfg.GetHistoryTTL()
+	if ttlDays <= 0 {
+		return nil
+	}
+
+	pruned, err := store.Prune(now.AddDate(0, 0, -ttlDays))
+	if err != nil {
+		return err
+	}
+	if pruned > 0 && bp.logger != nil {
+		bp.logger.Infof("Pruned %d processed bookmarks older than %d days", pruned, ttlDays)
 	}
+	return nil
 }
 
-func (bp *BookmarkProcessor) loadState() {
-	data, err := os.ReadFile(bp.statePath)
+// rehashOnRulesChange rehashes the store when its entries were hashed under other canonicalization rules, or
+// when entries were just imported from the JSON state. Otherwise opening the store costs nothing.
+func (bp *BookmarkProcessor) rehashOnRulesChange(store StateStore, imported bool) error {
+	version := bp.canonical.Version()
+	stored, err := store.RulesVersion()
 	if err != nil {
-		return
+		return err
+	}
+	if stored == version && !imported {
+		return nil
 	}
+	if _, err := rehashStore(store, bp.hashBookmark); err != nil {
+		return err
+	}
+	return store.SetRulesVersion(version)
+}
+
+// withStore opens the state store for the duration of fn, importing the old JSON state and rehashing entries
+// first. Only the daemon uses it, the store is not kept open between cycles so `kindle-send history` can read
+// it while the daemon runs.
+func (bp *BookmarkProcessor) withStore(fn func(StateStore) error) error {
+	store, err := OpenBoltStore(bp.storePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
 
-	if err := json.Unmarshal(data, &bp.state); err != nil {
-		util.Red.Printf("Warning: failed to load processed state: %v\n", err)
-		bp.state = ProcessedState{Bookmarks: make([]ProcessedBookmark, 0)}
+	migrated, err := migrateJSONState(bp.statePath, store)
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s: %w", bp.statePath, err)
 	}
+	if migrated > 0 {
+		util.Green.Printf("Migrated %d processed bookmarks from %s\n", migrated, bp.statePath)
+	}
+	if err := bp.rehashOnRulesChange(store, migrated > 0); err != nil {
+		return fmt.Errorf("failed to rehash processed bookmarks: %w", err)
+	}
+
+	return fn(store)
 }
 
-func (bp *BookmarkProcessor) saveState() error {
-	data, err := json.MarshalIndent(bp.state, "", "  ")
+// viewStore opens the state store read-only for fn, it never migrates or rehashes anything.
+// The error satisfies os.IsNotExist when the daemon has not created the store yet.
+func (bp *BookmarkProcessor) viewStore(fn func(StateStore) error) error {
+	store, err := OpenBoltStoreReadOnly(bp.storePath)
 	if err != nil {
 		return err
 	}
+	defer store.Close()
+	return fn(store)
+}
 
-	return os.WriteFile(bp.statePath, data, 0644)
+// updateStore opens the state store for changes made outside the daemon, such as forgetting a bookmark.
+// Migration and rehashing are left to the daemon.
+func (bp *BookmarkProcessor) updateStore(fn func(StateStore) error) error {
+	store, err := OpenBoltStore(bp.storePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return fn(store)
 }
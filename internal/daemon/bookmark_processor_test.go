@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+	"github.com/ryan-gang/kindle-send-daemon/internal/canonical"
+	"github.com/ryan-gang/kindle-send-daemon/internal/logger"
+	"github.com/ryan-gang/kindle-send-daemon/internal/simhash"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
+)
+
+func TestProcessedEntryRecordsOutcome(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	bookmark := bookmarks.Bookmark{URL: "https://example.com/a", Title: "Bookmarked title", Source: "raindrop"}
+	tests := []struct {
+		result      types.Result
+		wantErrText string
+		wantQueued  bool
+		wantEpub    string
+		wantTitle   string
+	}{
+		{types.Result{Status: types.StatusDownloaded, Path: "/books/a.epub", Title: "Page title", Size: 2048},
+			"", true, "/books/a.epub", "Page title"},
+		{types.Result{Status: types.StatusSent, Path: "/books/a.epub", Title: "Page title", Size: 2048},
+			"", false, "/books/a.epub", "Page title"},
+		{types.Result{Status: types.StatusDownloadFailed, Err: errors.New("404 Not Found")},
+			"404 Not Found", true, "", "Bookmarked title"},
+		{types.Result{Status: types.StatusMailFailed, Path: "/books/a.epub", Err: errors.New("smtp timeout")},
+			"smtp timeout", true, "/books/a.epub", "Bookmarked title"},
+		{types.Result{Status: types.StatusSkipped, Err: errors.New("not a supported link")},
+			"not a supported link", false, "", "Bookmarked title"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.result.Status), func(t *testing.T) {
+			bp := &BookmarkProcessor{
+				retries:   NewRetryQueue(filepath.Join(t.TempDir(), "retry_queue.json"), 3, time.Minute),
+				canonical: canonical.NewCanonicalizer(canonical.Rules{}),
+			}
+			hash := bp.hashBookmark(bookmark.URL)
+			bp.retries.RecordFailure(bookmark, hash, StageDownload, errors.New("earlier failure"), now.Add(-time.Hour))
+
+			entry := bp.processedEntry(bookmark, tt.result, now)
+			if entry.Status != tt.result.Status || entry.Hash != hash || !entry.Timestamp.Equal(now) {
+				t.Errorf("entry is %+v", entry)
+			}
+			if entry.ErrText != tt.wantErrText || entry.EpubPath != tt.wantEpub || entry.Title != tt.wantTitle {
+				t.Errorf("recorded error %q, file %q, title %q, want %q, %q, %q",
+					entry.ErrText, entry.EpubPath, entry.Title, tt.wantErrText, tt.wantEpub, tt.wantTitle)
+			}
+			if entry.Source != bookmark.Source || entry.Size != tt.result.Size {
+				t.Errorf("recorded source %q and size %d", entry.Source, entry.Size)
+			}
+			if queued := bp.retries.Contains(hash); queued != tt.wantQueued {
+				t.Errorf("bookmark still queued for a retry: %v, want %v", queued, tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestSkipDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	bp := &BookmarkProcessor{
+		cfg:       loadTestConfig(t, dir, map[string]any{"duplicate_window_days": 30}),
+		logger:    logger.NewConsoleLogger(),
+		storePath: filepath.Join(dir, "processed_bookmarks.db"),
+		canonical: canonical.NewCanonicalizer(canonical.Rules{}),
+	}
+	now := time.Now()
+	recent := simhash.Fingerprint(0xff00ff00ff00ff00)
+	expired := simhash.Fingerprint(0x0f0f0f0f0f0f0f0f)
+	err := bp.withStore(func(store StateStore) error {
+		return store.Commit(now,
+			ProcessedBookmark{URL: "https://example.com/recent", Hash: "recent", Timestamp: now.AddDate(0, 0, -2),
+				Status: types.StatusSent, Fingerprint: recent},
+			ProcessedBookmark{URL: "https://example.com/expired", Hash: "expired", Timestamp: now.AddDate(0, 0, -60),
+				Status: types.StatusSent, Fingerprint: expired})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := []bookmarks.Bookmark{{URL: "https://mirror.example/recent"}, {URL: "https://mirror.example/expired"}}
+	var results []types.Result
+	for i := range pending {
+		path := filepath.Join(dir, fmt.Sprintf("book-%d.epub", i))
+		if err := os.WriteFile(path, []byte("epub"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, types.Result{Status: types.StatusDownloaded, Path: path})
+	}
+	results[0].Fingerprint = recent ^ 1
+	results[1].Fingerprint = expired
+	kept := results[1].Path
+	removed := results[0].Path
+
+	results = bp.skipDuplicates(pending, results)
+	if results[0].Status != types.StatusSkipped || results[0].Path != "" {
+		t.Errorf("near-duplicate of a recent article is %+v, want it skipped", results[0])
+	}
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Errorf("book of the skipped article is still on disk: %v", err)
+	}
+	// Articles sent before the window are not compared against
+	if results[1].Status != types.StatusDownloaded {
+		t.Errorf("article sent before the window is %+v, want it kept", results[1])
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("book of the kept article: %v", err)
+	}
+}
+
+func TestCanonicalLookups(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/syndicated" {
+			fmt.Fprint(w, `<html><head><link rel="canonical" href="/original"></head></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><head></head></html>`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	bp := &BookmarkProcessor{
+		logger:     logger.NewConsoleLogger(),
+		storePath:  filepath.Join(dir, "processed_bookmarks.db"),
+		retries:    NewRetryQueue(filepath.Join(dir, "retry_queue.json"), 3, time.Minute),
+		canonical:  canonical.NewCanonicalizer(canonical.Rules{FollowRelCanonical: true}),
+		canonicals: make(map[string]canonicalLink),
+	}
+	original := server.URL + "/original"
+	pending := []bookmarks.Bookmark{{URL: server.URL + "/syndicated"}, {URL: server.URL + "/plain"}, {URL: server.URL + "/other"}}
+
+	var fresh []bookmarks.Bookmark
+	err := bp.withStore(func(store StateStore) error {
+		err := store.Commit(time.Now(), ProcessedBookmark{URL: original, Hash: bp.hashBookmark(original), Timestamp: time.Now()})
+		if err != nil {
+			return err
+		}
+		fresh, err = bp.filterNewBookmarks(context.Background(), store, pending)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fresh) != 2 || fresh[0].URL != pending[1].URL || fresh[1].URL != pending[2].URL {
+		t.Errorf("new bookmarks %+v, want the ones not sent under their canonical url", fresh)
+	}
+
+	// Recording the outcome reuses the links found while filtering
+	entry := bp.processedEntry(pending[0], types.Result{Status: types.StatusSkipped}, time.Now())
+	if entry.CanonicalHash != bp.hashBookmark(original) {
+		t.Errorf("entry recorded canonical %q, want %s", entry.CanonicalURL, original)
+	}
+	bp.processedEntry(pending[1], types.Result{Status: types.StatusSent}, time.Now())
+	for path, count := range hits {
+		if count != 1 {
+			t.Errorf("%s was fetched %d times", path, count)
+		}
+	}
+
+	// A cancelled cycle fetches nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = bp.withStore(func(store StateStore) error {
+		_, err := bp.filterNewBookmarks(ctx, store, []bookmarks.Bookmark{{URL: server.URL + "/late"}})
+		return err
+	})
+	if !errors.Is(err, context.Canceled) || hits["/late"] != 0 {
+		t.Errorf("cancelled filtering returned %v after %d fetches", err, hits["/late"])
+	}
+}
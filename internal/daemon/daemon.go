@@ -2,6 +2,7 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -9,8 +10,11 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
 	"github.com/ryan-gang/kindle-send-daemon/internal/config"
+	"github.com/ryan-gang/kindle-send-daemon/internal/epubgen"
 	"github.com/ryan-gang/kindle-send-daemon/internal/logger"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
 	"github.com/ryan-gang/kindle-send-daemon/internal/util"
 )
 
@@ -58,12 +62,12 @@ func (d *Daemon) Start() error {
 	}
 	defer d.logger.Close()
 
-	sigChan := d.setupSignalHandling()
+	d.setupSignalHandling()
 	d.setupTicker()
 	d.logStartupInfo()
 	d.processBookmarks()
 
-	return d.runEventLoop(sigChan)
+	return d.runEventLoop()
 }
 
 func (d *Daemon) validateConfiguration() error {
@@ -75,8 +79,17 @@ func (d *Daemon) validateConfiguration() error {
 		return fmt.Errorf("daemon is not enabled in configuration")
 	}
 
-	if d.cfg.GetBookmarkPath() == "" {
-		return fmt.Errorf("bookmark path is not configured")
+	if len(d.enabledProviders()) == 0 {
+		return fmt.Errorf("no bookmark providers are configured")
+	}
+
+	// The daemon mails every book it makes
+	format, err := epubgen.ParseFormat(d.cfg.GetOutputFormat())
+	if err != nil {
+		return fmt.Errorf("output_format: %w", err)
+	}
+	if err := epubgen.CheckFormat(format, epubgen.MailFormats); err != nil {
+		return fmt.Errorf("output_format: %w", err)
 	}
 
 	if d.isRunning() {
@@ -94,10 +107,21 @@ func (d *Daemon) initializeServices() error {
 	return nil
 }
 
-func (d *Daemon) setupSignalHandling() chan os.Signal {
+// setupSignalHandling cancels the daemon context on SIGINT or SIGTERM, which also aborts a cycle that is still running
+func (d *Daemon) setupSignalHandling() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	return sigChan
+
+	go func() {
+		defer signal.Stop(sigChan)
+		select {
+		case sig := <-sigChan:
+			d.logger.Infof("Received signal: %v", sig)
+			util.Cyan.Printf("Received signal: %v\n", sig)
+			d.cancel()
+		case <-d.ctx.Done():
+		}
+	}()
 }
 
 func (d *Daemon) setupTicker() {
@@ -107,26 +131,34 @@ func (d *Daemon) setupTicker() {
 
 func (d *Daemon) logStartupInfo() {
 	util.GreenBold.Printf("Kindle-send daemon started, checking bookmarks every %d minutes\n", d.cfg.GetCheckInterval())
-	util.Cyan.Printf("Monitoring bookmark path: %s\n", d.cfg.GetBookmarkPath())
+	for _, provider := range d.enabledProviders() {
+		util.Cyan.Printf("Monitoring provider: %s (%s)\n", provider.Name, provider.ProviderType())
+	}
 	util.Cyan.Printf("PID file: %s\n", d.cfg.GetPidFile())
 	util.Cyan.Printf("Log file: %s\n", d.cfg.GetLogPath())
 
 	d.logger.Infof("Daemon started with PID %d", os.Getpid())
-	d.logger.Infof("Monitoring bookmark path: %s", d.cfg.GetBookmarkPath())
+	for _, provider := range d.enabledProviders() {
+		d.logger.Infof("Monitoring provider: %s (%s)", provider.Name, provider.ProviderType())
+	}
 	d.logger.Infof("Check interval: %d minutes", d.cfg.GetCheckInterval())
 }
 
-func (d *Daemon) runEventLoop(sigChan chan os.Signal) error {
+// enabledProviders returns the provider entries from the configuration that are switched on
+func (d *Daemon) enabledProviders() []bookmarks.ProviderConfig {
+	var enabled []bookmarks.ProviderConfig
+	for _, provider := range d.cfg.GetProviders() {
+		if provider.Enabled {
+			enabled = append(enabled, provider)
+		}
+	}
+	return enabled
+}
+
+func (d *Daemon) runEventLoop() error {
 	for {
 		select {
 		case <-d.ctx.Done():
-			d.logger.Info("Daemon context cancelled")
-			util.Cyan.Println("Daemon context cancelled")
-			d.cleanup()
-			return nil
-		case sig := <-sigChan:
-			d.logger.Infof("Received signal: %v", sig)
-			util.Cyan.Printf("Received signal: %v\n", sig)
 			d.Stop()
 			return nil
 		case <-d.ticker.C:
@@ -159,7 +191,7 @@ func (d *Daemon) processBookmarks() {
 		return
 	}
 
-	bookmarks, err := d.processor.ReadBookmarks()
+	bookmarks, err := d.processor.ReadBookmarks(d.ctx)
 	if err != nil {
 		d.logger.Errorf("Error reading bookmarks: %v", err)
 		util.Red.Printf("Error reading bookmarks: %v\n", err)
@@ -175,16 +207,27 @@ func (d *Daemon) processBookmarks() {
 	d.logger.Infof("Found %d new bookmarks to process", len(bookmarks))
 	util.CyanBold.Printf("Found %d new bookmarks to process\n", len(bookmarks))
 
-	processed, err := d.processor.ProcessBookmarks(bookmarks)
+	processed, err := d.processor.ProcessBookmarks(d.ctx, bookmarks)
+	if errors.Is(err, context.Canceled) {
+		d.logger.Info("Bookmark check cycle aborted, unsent bookmarks will be picked up on the next start")
+		util.Cyan.Println("Bookmark check cycle aborted")
+		return
+	}
 	if err != nil {
 		d.logger.Errorf("Error processing bookmarks: %v", err)
 		util.Red.Printf("Error processing bookmarks: %v\n", err)
 		return
 	}
 
-	if len(processed) > 0 {
-		d.logger.Infof("Successfully processed and sent %d bookmarks", len(processed))
-		util.GreenBold.Printf("Successfully processed and sent %d bookmarks\n", len(processed))
+	sent := 0
+	for _, bookmark := range processed {
+		if bookmark.Status == types.StatusSent {
+			sent++
+		}
+	}
+	if sent > 0 {
+		d.logger.Infof("Successfully processed and sent %d of %d bookmarks", sent, len(processed))
+		util.GreenBold.Printf("Successfully processed and sent %d of %d bookmarks\n", sent, len(processed))
 	}
 }
 
@@ -228,7 +271,9 @@ func (d *Daemon) Status() error {
 	if d.isRunning() {
 		pidData, _ := os.ReadFile(d.cfg.GetPidFile())
 		util.Green.Printf("Daemon is running (PID: %s)\n", string(pidData))
-		util.Cyan.Printf("Bookmark path: %s\n", d.cfg.GetBookmarkPath())
+		for _, provider := range d.enabledProviders() {
+			util.Cyan.Printf("Provider: %s (%s)\n", provider.Name, provider.ProviderType())
+		}
 		util.Cyan.Printf("Check interval: %d minutes\n", d.cfg.GetCheckInterval())
 		return nil
 	} else {
@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/config"
+	"github.com/ryan-gang/kindle-send-daemon/internal/logger"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
+)
+
+// HistoryFilter narrows down processed bookmarks, zero values match everything
+type HistoryFilter struct {
+	Since    time.Time
+	Until    time.Time
+	Status   types.Status
+	Domain   string
+	Provider string
+}
+
+// Matches reports whether a processed bookmark passes every set filter
+func (f HistoryFilter) Matches(bookmark ProcessedBookmark) bool {
+	if !f.Since.IsZero() && bookmark.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && bookmark.Timestamp.After(f.Until) {
+		return false
+	}
+	if f.Status != "" && bookmark.EffectiveStatus() != f.Status {
+		return false
+	}
+	if f.Provider != "" && !strings.EqualFold(bookmark.Source, f.Provider) {
+		return false
+	}
+	if f.Domain != "" && !matchesDomain(bookmark.URL, f.Domain) {
+		return false
+	}
+	return true
+}
+
+// matchesDomain accepts the domain itself and any of its subdomains
+func matchesDomain(bookmarkURL, domain string) bool {
+	parsed, err := url.Parse(bookmarkURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	domain = strings.ToLower(strings.TrimPrefix(domain, "www."))
+	host = strings.TrimPrefix(host, "www.")
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// OpenHistory loads the processed state for inspection, without setting up providers. It logs to the console
+// rather than the daemon's log file, and leaves migrating and rehashing the state to the daemon.
+func OpenHistory(cfg config.ConfigProvider) *BookmarkProcessor {
+	processor := newStateProcessor(cfg)
+	processor.logger = logger.NewConsoleLogger()
+	if err := processor.retries.Load(); err != nil {
+		processor.logger.Warnf("Failed to load retry queue: %v", err)
+	}
+	return processor
+}
+
+// History returns the processed bookmarks matching filter, newest first
+func (bp *BookmarkProcessor) History(filter HistoryFilter) ([]ProcessedBookmark, error) {
+	var matched []ProcessedBookmark
+	match := func(bookmark ProcessedBookmark) error {
+		if filter.Matches(bookmark) {
+			matched = append(matched, bookmark)
+		}
+		return nil
+	}
+	err := bp.viewStore(func(store StateStore) error {
+		return store.ForEach(match)
+	})
+	if os.IsNotExist(err) {
+		// The daemon has not imported the state of an earlier version yet
+		state, err := readJSONState(bp.statePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, bookmark := range state.Bookmarks {
+			match(bookmark)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+	return matched, nil
+}
+
+// Forget drops a bookmark from the processed state and retry queue so the next cycle sends it again.
+// It reports whether the url was known at all. Entries the daemon has not rehashed yet are matched by url.
+func (bp *BookmarkProcessor) Forget(bookmarkURL string) (bool, error) {
+	hash := bp.hashBookmark(bookmarkURL)
+	bp.retries.Rehash(bp.hashBookmark)
+	queued := bp.retries.Contains(hash)
+	bp.retries.Remove(hash)
+
+	var stored bool
+	err := bp.updateStore(func(store StateStore) error {
+		var stale []string
+		err := store.ForEach(func(bookmark ProcessedBookmark) error {
+			if bookmark.Hash != hash && bp.hashBookmark(bookmark.URL) == hash {
+				stale = append(stale, bookmark.Hash)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range append(stale, hash) {
+			deleted, err := store.Delete(key)
+			if err != nil {
+				return err
+			}
+			stored = stored || deleted
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if !queued && !stored {
+		return false, nil
+	}
+	return true, bp.retries.Save()
+}
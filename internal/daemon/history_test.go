@@ -0,0 +1,160 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+	"github.com/ryan-gang/kindle-send-daemon/internal/canonical"
+	"github.com/ryan-gang/kindle-send-daemon/internal/config"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
+)
+
+func TestHistoryFilterMatches(t *testing.T) {
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	bookmark := ProcessedBookmark{URL: "https://blog.Example.com/post", Timestamp: day, Source: "raindrop", Status: types.StatusMailFailed}
+	legacy := ProcessedBookmark{URL: "https://www.example.org/", Timestamp: day}
+
+	tests := []struct {
+		name     string
+		filter   HistoryFilter
+		bookmark ProcessedBookmark
+		want     bool
+	}{
+		{"no filter", HistoryFilter{}, bookmark, true},
+		{"since before", HistoryFilter{Since: day.Add(-time.Hour)}, bookmark, true},
+		{"since after", HistoryFilter{Since: day.Add(time.Hour)}, bookmark, false},
+		{"until after", HistoryFilter{Until: day.Add(time.Hour)}, bookmark, true},
+		{"until before", HistoryFilter{Until: day.Add(-time.Hour)}, bookmark, false},
+		{"status", HistoryFilter{Status: types.StatusMailFailed}, bookmark, true},
+		{"other status", HistoryFilter{Status: types.StatusSent}, bookmark, false},
+		{"untracked status counts as sent", HistoryFilter{Status: types.StatusSent}, legacy, true},
+		{"provider ignores case", HistoryFilter{Provider: "Raindrop"}, bookmark, true},
+		{"other provider", HistoryFilter{Provider: "safari"}, bookmark, false},
+		{"subdomain", HistoryFilter{Domain: "example.com"}, bookmark, true},
+		{"exact host", HistoryFilter{Domain: "blog.example.com"}, bookmark, true},
+		{"www is ignored", HistoryFilter{Domain: "example.org"}, legacy, true},
+		{"www in the filter", HistoryFilter{Domain: "www.example.org"}, legacy, true},
+		{"suffix is not a subdomain", HistoryFilter{Domain: "ample.com"}, bookmark, false},
+		{"every filter", HistoryFilter{Since: day.Add(-time.Hour), Until: day, Status: types.StatusMailFailed,
+			Domain: "example.com", Provider: "raindrop"}, bookmark, true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(tt.bookmark); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	dir := t.TempDir()
+	bp := &BookmarkProcessor{
+		statePath: filepath.Join(dir, "processed_bookmarks.json"),
+		storePath: filepath.Join(dir, "processed_bookmarks.db"),
+		retries:   NewRetryQueue(filepath.Join(dir, "retry_queue.json"), 3, time.Minute),
+		canonical: canonical.NewCanonicalizer(canonical.Rules{}),
+	}
+	sent := "https://example.com/sent"
+	failing := "https://example.com/failing"
+	err := bp.withStore(func(store StateStore) error {
+		return store.Commit(time.Now(), ProcessedBookmark{URL: sent, Hash: bp.hashBookmark(sent), Timestamp: time.Now()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.retries.RecordFailure(bookmarks.Bookmark{URL: failing}, bp.hashBookmark(failing), StageDownload, errors.New("404"), time.Now())
+
+	// Urls are matched in their canonical form
+	if found, err := bp.Forget("http://www.example.com/sent/?utm_source=x"); err != nil || !found {
+		t.Fatalf("Forget(sent) = %v, %v", found, err)
+	}
+	if entries, _ := bp.History(HistoryFilter{}); len(entries) != 0 {
+		t.Errorf("forgotten bookmark is still in the history: %+v", entries)
+	}
+
+	if found, err := bp.Forget(failing); err != nil || !found {
+		t.Fatalf("Forget(failing) = %v, %v", found, err)
+	}
+	saved := NewRetryQueue(bp.retries.path, 3, time.Minute)
+	if err := saved.Load(); err != nil || saved.Contains(bp.hashBookmark(failing)) {
+		t.Errorf("forgotten bookmark is still queued on disk: %v", err)
+	}
+
+	if found, err := bp.Forget("https://example.com/unknown"); err != nil || found {
+		t.Errorf("Forget(unknown) = %v, %v", found, err)
+	}
+}
+
+// loadTestConfig writes a config with the given settings into dir and loads it
+func loadTestConfig(t *testing.T, dir string, settings map[string]any) config.ConfigProvider {
+	t.Helper()
+	password, err := config.Encrypt("me@example.com", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]any{"sender": "me@example.com", "password": password, "pid_file": filepath.Join(dir, "kindle-send.pid")}
+	for key, value := range settings {
+		values[key] = value
+	}
+	data, _ := json.Marshal(values)
+	path := filepath.Join(dir, "KindleConfig.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestOpenHistoryLogs(t *testing.T) {
+	dir := t.TempDir()
+	bp := OpenHistory(loadTestConfig(t, dir, nil))
+	if bp.logger == nil {
+		t.Fatal("history has no logger")
+	}
+	// Logging paths such as pruning must not panic
+	bp.logger.Infof("history opened for %s", dir)
+}
+
+func TestHistoryIsReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := loadTestConfig(t, dir, nil)
+	statePath := filepath.Join(dir, "processed_bookmarks.json")
+	retryPath := filepath.Join(dir, "retry_queue.json")
+
+	// State of an earlier version, queued under a hash the current rules don't produce
+	state, _ := json.Marshal(ProcessedState{Bookmarks: []ProcessedBookmark{
+		{URL: "https://example.com/old", Hash: "legacy", Timestamp: time.Now()},
+	}})
+	queue, _ := json.Marshal([]RetryEntry{{URL: "https://example.com/failing", Hash: "legacy-retry", Stage: StageDownload}})
+	if err := os.WriteFile(statePath, state, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(retryPath, queue, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := OpenHistory(cfg).History(HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].URL != "https://example.com/old" {
+		t.Errorf("history of the old state is %+v", entries)
+	}
+
+	if _, err := os.Stat(statePath); err != nil {
+		t.Errorf("listing the history moved the old state: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "processed_bookmarks.db")); !os.IsNotExist(err) {
+		t.Errorf("listing the history created the store: %v", err)
+	}
+	if data, _ := os.ReadFile(retryPath); string(data) != string(queue) {
+		t.Errorf("listing the history rewrote the retry queue: %s", data)
+	}
+}
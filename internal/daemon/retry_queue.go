@@ -0,0 +1,231 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+)
+
+const (
+	StageDownload = "download"
+	StageMail     = "mail"
+
+	// Backoff doubles from the base delay but never waits longer than this
+	maxRetryDelay = 24 * time.Hour
+)
+
+// RetryEntry tracks a bookmark that failed to download or send
+type RetryEntry struct {
+	URL         string    `json:"url"`
+	Hash        string    `json:"hash"`
+	Title       string    `json:"title,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Stage       string    `json:"stage"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	FirstFailed time.Time `json:"first_failed"`
+	LastAttempt time.Time `json:"last_attempt"`
+	NextAttempt time.Time `json:"next_attempt"`
+	// Dead entries ran out of attempts, they are kept for inspection but never retried
+	Dead bool `json:"dead"`
+}
+
+// RetryQueue is a durable record of failed bookmarks, persisted next to the processed state
+type RetryQueue struct {
+	path        string
+	maxAttempts int
+	baseDelay   time.Duration
+	entries     map[string]*RetryEntry
+	// changed holds the hashes added, updated or removed since the queue was last loaded or saved
+	changed map[string]bool
+}
+
+func NewRetryQueue(path string, maxAttempts int, baseDelay time.Duration) *RetryQueue {
+	return &RetryQueue{
+		path:        path,
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		entries:     make(map[string]*RetryEntry),
+		changed:     make(map[string]bool),
+	}
+}
+
+// Load reads the queue from disk, a missing file is an empty queue
+func (q *RetryQueue) Load() error {
+	entries, err := q.read()
+	if err != nil {
+		return err
+	}
+	q.entries = entries
+	q.changed = make(map[string]bool)
+	return nil
+}
+
+// read returns the entries saved on disk by hash
+func (q *RetryQueue) read() (map[string]*RetryEntry, error) {
+	data, err := os.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return make(map[string]*RetryEntry), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*RetryEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*RetryEntry, len(list))
+	for _, entry := range list {
+		entries[entry.Hash] = entry
+	}
+	return entries, nil
+}
+
+// Save writes the queue to a temp file and renames it over the old one, so a crash never leaves it half written.
+// Only the entries changed since the last load are written over what is on disk, so entries another process
+// removed meanwhile, eg. `kindle-send history forget` during a daemon cycle, stay removed.
+func (q *RetryQueue) Save() error {
+	saved, err := q.read()
+	if err != nil {
+		return err
+	}
+	for hash := range q.changed {
+		if entry, ok := q.entries[hash]; ok {
+			saved[hash] = entry
+		} else {
+			delete(saved, hash)
+		}
+	}
+	q.entries = saved
+	q.changed = make(map[string]bool)
+
+	data, err := json.MarshalIndent(q.Entries(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.path)
+}
+
+// Rehash rekeys entries whose stored hash no longer matches hash(url), keeping the one with more attempts on collisions
+func (q *RetryQueue) Rehash(hash func(string) string) bool {
+	changed := false
+	for key, entry := range q.entries {
+		rehashed := hash(entry.URL)
+		if rehashed == key {
+			continue
+		}
+		delete(q.entries, key)
+		q.changed[key], q.changed[rehashed] = true, true
+		changed = true
+		if existing, ok := q.entries[rehashed]; ok && existing.Attempts >= entry.Attempts {
+			continue
+		}
+		entry.Hash = rehashed
+		q.entries[rehashed] = entry
+	}
+	return changed
+}
+
+// RecordFailure counts a failed attempt and schedules the next one, parking the entry once attempts run out
+func (q *RetryQueue) RecordFailure(bookmark bookmarks.Bookmark, hash, stage string, cause error, now time.Time) *RetryEntry {
+	entry, ok := q.entries[hash]
+	if !ok {
+		entry = &RetryEntry{
+			URL:         bookmark.URL,
+			Hash:        hash,
+			Title:       bookmark.Title,
+			Source:      bookmark.Source,
+			FirstFailed: now,
+		}
+		q.entries[hash] = entry
+	}
+	q.changed[hash] = true
+
+	entry.Stage = stage
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	entry.LastAttempt = now
+
+	if entry.Attempts >= q.maxAttempts {
+		entry.Dead = true
+		entry.NextAttempt = time.Time{}
+	} else {
+		entry.NextAttempt = now.Add(q.backoff(entry.Attempts))
+	}
+	return entry
+}
+
+// backoff returns the wait after the given number of failed attempts
+func (q *RetryQueue) backoff(attempts int) time.Duration {
+	delay := q.baseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
+
+// Remove drops an entry, used once a retried bookmark goes through
+func (q *RetryQueue) Remove(hash string) {
+	delete(q.entries, hash)
+	q.changed[hash] = true
+}
+
+// Contains reports whether a bookmark is waiting for a retry or parked as dead-letter
+func (q *RetryQueue) Contains(hash string) bool {
+	_, ok := q.entries[hash]
+	return ok
+}
+
+// Due returns the bookmarks of live entries whose next attempt is not in the future
+func (q *RetryQueue) Due(now time.Time) []bookmarks.Bookmark {
+	var due []bookmarks.Bookmark
+	for _, entry := range q.Entries() {
+		if !entry.Dead && !entry.NextAttempt.After(now) {
+			due = append(due, bookmarks.Bookmark{
+				URL:       entry.URL,
+				Title:     entry.Title,
+				Source:    entry.Source,
+				Timestamp: entry.FirstFailed,
+			})
+		}
+	}
+	return due
+}
+
+// Entries returns every entry ordered by first failure
+func (q *RetryQueue) Entries() []*RetryEntry {
+	entries := make([]*RetryEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FirstFailed.Before(entries[j].FirstFailed)
+	})
+	return entries
+}
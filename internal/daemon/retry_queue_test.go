@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/bookmarks"
+)
+
+func TestRetryQueueBackoff(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	q := NewRetryQueue(filepath.Join(t.TempDir(), "retry_queue.json"), 20, 10*time.Minute)
+	bookmark := bookmarks.Bookmark{URL: "https://example.com/a", Title: "A", Source: "file"}
+
+	// The delay doubles from the base with every failure until it reaches the cap
+	want := []time.Duration{10 * time.Minute, 20 * time.Minute, 40 * time.Minute, 80 * time.Minute, 160 * time.Minute,
+		320 * time.Minute, 640 * time.Minute, 1280 * time.Minute, maxRetryDelay, maxRetryDelay}
+	for i, delay := range want {
+		entry := q.RecordFailure(bookmark, "a", StageDownload, errors.New("timeout"), now)
+		if entry.Attempts != i+1 {
+			t.Fatalf("attempt %d recorded as %d", i+1, entry.Attempts)
+		}
+		if got := entry.NextAttempt.Sub(now); got != delay {
+			t.Errorf("after %d failures the next attempt is in %s, want %s", i+1, got, delay)
+		}
+		now = entry.NextAttempt
+	}
+}
+
+func TestRetryQueueDueAndDead(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	q := NewRetryQueue(filepath.Join(t.TempDir(), "retry_queue.json"), 3, time.Hour)
+	a := bookmarks.Bookmark{URL: "https://example.com/a", Source: "file"}
+	b := bookmarks.Bookmark{URL: "https://example.com/b", Source: "raindrop"}
+
+	q.RecordFailure(a, "a", StageDownload, errors.New("404"), now)
+	q.RecordFailure(b, "b", StageMail, errors.New("smtp"), now.Add(30*time.Minute))
+
+	if due := q.Due(now.Add(59 * time.Minute)); len(due) != 0 {
+		t.Errorf("%d bookmarks due before their backoff passed", len(due))
+	}
+	due := q.Due(now.Add(time.Hour))
+	if len(due) != 1 || due[0].URL != a.URL || !due[0].Timestamp.Equal(now) {
+		t.Errorf("due after an hour: %+v, want only %s", due, a.URL)
+	}
+
+	// The third failure parks the entry, it is kept but never retried
+	q.RecordFailure(a, "a", StageDownload, errors.New("404"), now.Add(time.Hour))
+	entry := q.RecordFailure(a, "a", StageDownload, errors.New("404"), now.Add(3*time.Hour))
+	if !entry.Dead || !entry.NextAttempt.IsZero() {
+		t.Errorf("entry after %d attempts is %+v, want it parked", entry.Attempts, entry)
+	}
+	if !q.Contains("a") {
+		t.Error("parked entry was dropped")
+	}
+	for _, due := range q.Due(now.AddDate(1, 0, 0)) {
+		if due.URL == a.URL {
+			t.Error("parked entry is due")
+		}
+	}
+
+	q.Remove("b")
+	if q.Contains("b") {
+		t.Error("removed entry is still queued")
+	}
+}
+
+func TestRetryQueueSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry_queue.json")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	q := NewRetryQueue(path, 2, time.Minute)
+	q.RecordFailure(bookmarks.Bookmark{URL: "https://example.com/a", Title: "A"}, "a", StageDownload, errors.New("404"), now)
+	q.RecordFailure(bookmarks.Bookmark{URL: "https://example.com/b"}, "b", StageMail, errors.New("smtp"), now.Add(time.Minute))
+	q.RecordFailure(bookmarks.Bookmark{URL: "https://example.com/b"}, "b", StageMail, errors.New("smtp"), now.Add(2*time.Minute))
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewRetryQueue(path, 2, time.Minute)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Entries(), q.Entries()) {
+		t.Errorf("loaded queue %+v, want %+v", loaded.Entries(), q.Entries())
+	}
+
+	// Saving goes through a temporary file that never stays behind
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("%d files next to the queue, want only the queue", len(files))
+	}
+
+	missing := NewRetryQueue(filepath.Join(t.TempDir(), "absent.json"), 2, time.Minute)
+	if err := missing.Load(); err != nil || len(missing.Entries()) != 0 {
+		t.Errorf("missing queue loaded %d entries, %v", len(missing.Entries()), err)
+	}
+}
+
+func TestRetryQueueKeepsRemovalsByOthers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry_queue.json")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	a := bookmarks.Bookmark{URL: "https://example.com/a"}
+	b := bookmarks.Bookmark{URL: "https://example.com/b"}
+
+	daemon := NewRetryQueue(path, 5, time.Minute)
+	daemon.RecordFailure(a, "a", StageDownload, errors.New("404"), now)
+	daemon.RecordFailure(b, "b", StageDownload, errors.New("404"), now)
+	if err := daemon.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// history forget runs while the daemon is in the middle of a cycle
+	forget := NewRetryQueue(path, 5, time.Minute)
+	if err := forget.Load(); err != nil {
+		t.Fatal(err)
+	}
+	forget.Remove("a")
+	if err := forget.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon.RecordFailure(b, "b", StageDownload, errors.New("404"), now.Add(time.Minute))
+	if err := daemon.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewRetryQueue(path, 5, time.Minute)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Contains("a") {
+		t.Error("forgotten entry came back when the daemon saved")
+	}
+	if entries := loaded.Entries(); len(entries) != 1 || entries[0].Attempts != 2 {
+		t.Errorf("saved entries %+v, want b after its second attempt", entries)
+	}
+
+	// Once the file is gone, loading empties the queue
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.Load(); err != nil || len(daemon.Entries()) != 0 {
+		t.Errorf("queue kept %d entries after its file was removed, %v", len(daemon.Entries()), err)
+	}
+}
@@ -0,0 +1,401 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// StateStore persists processed bookmarks keyed by their hash
+type StateStore interface {
+	// Get looks up a single bookmark by hash, or by the hash of its rel=canonical url
+	Get(hash string) (ProcessedBookmark, bool, error)
+
+	// Commit writes the given bookmarks and the last check time in one transaction
+	Commit(lastCheck time.Time, bookmarks ...ProcessedBookmark) error
+
+	// Delete removes a bookmark and its canonical alias, reporting whether it existed
+	Delete(hash string) (bool, error)
+
+	// ForEach calls fn for every stored bookmark in hash order, stopping at the first error
+	ForEach(fn func(ProcessedBookmark) error) error
+
+	// ForEachSince calls fn for the bookmarks processed at or after since, oldest first
+	ForEachSince(since time.Time, fn func(ProcessedBookmark) error) error
+
+	// Prune removes bookmarks processed before the given time and returns how many went
+	Prune(before time.Time) (int, error)
+
+	// LastCheck returns when the daemon last committed a cycle
+	LastCheck() (time.Time, error)
+
+	// RulesVersion returns the version of the canonicalization rules the stored hashes were made with
+	RulesVersion() (string, error)
+
+	// SetRulesVersion records the rules version once every entry is hashed with it
+	SetRulesVersion(version string) error
+
+	Close() error
+}
+
+var (
+	bookmarksBucket = []byte("bookmarks")
+	metaBucket      = []byte("meta")
+	aliasesBucket   = []byte("aliases")
+	timelineBucket  = []byte("timeline")
+	lastCheckKey    = []byte("last_check")
+	rulesVersionKey = []byte("rules_version")
+)
+
+// BoltStore implements StateStore on a bbolt database file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the database at path. bbolt locks the file
+// while it is open, so a second process waits up to a few seconds for it.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bookmarksBucket, metaBucket, aliasesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(timelineBucket) != nil {
+			return nil
+		}
+		// Stores written before the timeline existed get it built once
+		timeline, err := tx.CreateBucket(timelineBucket)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bookmarksBucket).ForEach(func(_, data []byte) error {
+			var bookmark ProcessedBookmark
+			if err := json.Unmarshal(data, &bookmark); err != nil {
+				return err
+			}
+			return timeline.Put(timelineKey(bookmark), nil)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise state store: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// timelineKey orders bookmarks by when they were processed, the hash keeps keys of the same instant apart
+func timelineKey(bookmark ProcessedBookmark) []byte {
+	return timelineSeek(bookmark.Timestamp, bookmark.Hash)
+}
+
+func timelineSeek(t time.Time, hash string) []byte {
+	key := make([]byte, 8, 8+len(hash))
+	binary.BigEndian.PutUint64(key, uint64(max(t.UnixNano(), 0)))
+	return append(key, hash...)
+}
+
+// OpenBoltStoreReadOnly opens an existing database for reading, it never creates or changes anything.
+// Readers share the file lock, so it only waits for a daemon cycle that is writing.
+func OpenBoltStoreReadOnly(path string) (*BoltStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store %s: %w", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(hash string) (ProcessedBookmark, bool, error) {
+	var bookmark ProcessedBookmark
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bookmarksBucket)
+		data := bucket.Get([]byte(hash))
+		if data == nil {
+			// A page syndicated under another url points at the entry of the url it was first sent as
+			target := tx.Bucket(aliasesBucket).Get([]byte(hash))
+			if target == nil {
+				return nil
+			}
+			if data = bucket.Get(target); data == nil {
+				return nil
+			}
+		}
+		found = true
+		return json.Unmarshal(data, &bookmark)
+	})
+	return bookmark, found, err
+}
+
+func (s *BoltStore) Commit(lastCheck time.Time, bookmarks ...ProcessedBookmark) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bookmarksBucket)
+		aliases := tx.Bucket(aliasesBucket)
+		timeline := tx.Bucket(timelineBucket)
+		for _, bookmark := range bookmarks {
+			data, err := json.Marshal(bookmark)
+			if err != nil {
+				return err
+			}
+			if old := bucket.Get([]byte(bookmark.Hash)); old != nil {
+				var previous ProcessedBookmark
+				if err := json.Unmarshal(old, &previous); err != nil {
+					return err
+				}
+				if err := timeline.Delete(timelineKey(previous)); err != nil {
+					return err
+				}
+			}
+			if err := timeline.Put(timelineKey(bookmark), nil); err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(bookmark.Hash), data); err != nil {
+				return err
+			}
+			if bookmark.CanonicalHash != "" && bookmark.CanonicalHash != bookmark.Hash {
+				if err := aliases.Put([]byte(bookmark.CanonicalHash), []byte(bookmark.Hash)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if lastCheck.IsZero() {
+			return nil
+		}
+		stamp, err := lastCheck.MarshalText()
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put(lastCheckKey, stamp)
+	})
+}
+
+func (s *BoltStore) Delete(hash string) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bookmarksBucket)
+		data := bucket.Get([]byte(hash))
+		if found = data != nil; !found {
+			return nil
+		}
+		var bookmark ProcessedBookmark
+		if err := json.Unmarshal(data, &bookmark); err != nil {
+			return err
+		}
+		if err := bucket.Delete([]byte(hash)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(timelineBucket).Delete(timelineKey(bookmark)); err != nil {
+			return err
+		}
+
+		// Only the bookmark's own alias can point at it
+		aliases := tx.Bucket(aliasesBucket)
+		if bookmark.CanonicalHash == "" || !bytes.Equal(aliases.Get([]byte(bookmark.CanonicalHash)), []byte(hash)) {
+			return nil
+		}
+		return aliases.Delete([]byte(bookmark.CanonicalHash))
+	})
+	return found, err
+}
+
+func (s *BoltStore) ForEach(fn func(ProcessedBookmark) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bookmarksBucket).ForEach(func(_, data []byte) error {
+			var bookmark ProcessedBookmark
+			if err := json.Unmarshal(data, &bookmark); err != nil {
+				return err
+			}
+			return fn(bookmark)
+		})
+	})
+}
+
+func (s *BoltStore) ForEachSince(since time.Time, fn func(ProcessedBookmark) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bookmarksBucket)
+		cursor := tx.Bucket(timelineBucket).Cursor()
+		for key, _ := cursor.Seek(timelineSeek(since, "")); key != nil; key, _ = cursor.Next() {
+			data := bucket.Get(key[8:])
+			if data == nil {
+				continue
+			}
+			var bookmark ProcessedBookmark
+			if err := json.Unmarshal(data, &bookmark); err != nil {
+				return err
+			}
+			if err := fn(bookmark); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Prune(before time.Time) (int, error) {
+	pruned := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bookmarksBucket)
+		timeline := tx.Bucket(timelineBucket)
+		cursor := timeline.Cursor()
+		end := timelineSeek(before, "")
+		// Keys are only valid until the bucket changes, so they are copied before deleting anything
+		var expired [][]byte
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key, end) < 0; key, _ = cursor.Next() {
+			expired = append(expired, bytes.Clone(key))
+		}
+		for _, key := range expired {
+			if err := timeline.Delete(key); err != nil {
+				return err
+			}
+			if bucket.Get(key[8:]) == nil {
+				continue
+			}
+			if err := bucket.Delete(key[8:]); err != nil {
+				return err
+			}
+			pruned++
+		}
+		if pruned == 0 {
+			return nil
+		}
+		return dropAliases(tx)
+	})
+	return pruned, err
+}
+
+// dropAliases removes aliases whose bookmark is gone
+func dropAliases(tx *bolt.Tx) error {
+	bookmarks := tx.Bucket(bookmarksBucket)
+	cursor := tx.Bucket(aliasesBucket).Cursor()
+	for key, target := cursor.First(); key != nil; key, target = cursor.Next() {
+		if bookmarks.Get(target) != nil {
+			continue
+		}
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) LastCheck() (time.Time, error) {
+	var lastCheck time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stamp := tx.Bucket(metaBucket).Get(lastCheckKey)
+		if stamp == nil {
+			return nil
+		}
+		return lastCheck.UnmarshalText(stamp)
+	})
+	return lastCheck, err
+}
+
+func (s *BoltStore) RulesVersion() (string, error) {
+	var version string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		version = string(tx.Bucket(metaBucket).Get(rulesVersionKey))
+		return nil
+	})
+	return version, err
+}
+
+func (s *BoltStore) SetRulesVersion(version string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(rulesVersionKey, []byte(version))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// migrateJSONState imports the processed_bookmarks.json written by earlier versions into store,
+// then renames the file so the import only ever runs once
+func migrateJSONState(jsonPath string, store StateStore) (int, error) {
+	state, err := readJSONState(jsonPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	// Entries already in the store are newer than anything in the old file
+	var missing []ProcessedBookmark
+	for _, bookmark := range state.Bookmarks {
+		_, found, err := store.Get(bookmark.Hash)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			missing = append(missing, bookmark)
+		}
+	}
+
+	if err := store.Commit(state.LastCheck, missing...); err != nil {
+		return 0, err
+	}
+	return len(missing), os.Rename(jsonPath, jsonPath+".migrated")
+}
+
+// readJSONState parses the processed_bookmarks.json written by earlier versions
+func readJSONState(jsonPath string) (ProcessedState, error) {
+	var state ProcessedState
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse %s: %w", jsonPath, err)
+	}
+	return state, nil
+}
+
+// rehashStore moves entries stored under a hash that no longer matches their url, which happens
+// for entries written before urls were canonicalized and whenever the canonicalization rules change.
+// When two entries collapse into one hash the most recent is kept.
+func rehashStore(store StateStore, hash func(string) string) (int, error) {
+	var stale []ProcessedBookmark
+	err := store.ForEach(func(bookmark ProcessedBookmark) error {
+		if hash(bookmark.URL) != bookmark.Hash {
+			stale = append(stale, bookmark)
+		}
+		return nil
+	})
+	if err != nil || len(stale) == 0 {
+		return 0, err
+	}
+
+	for _, bookmark := range stale {
+		if _, err := store.Delete(bookmark.Hash); err != nil {
+			return 0, err
+		}
+		bookmark.Hash = hash(bookmark.URL)
+		existing, found, err := store.Get(bookmark.Hash)
+		if err != nil {
+			return 0, err
+		}
+		if found && existing.Hash == bookmark.Hash && existing.Timestamp.After(bookmark.Timestamp) {
+			continue
+		}
+		if err := store.Commit(time.Time{}, bookmark); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,214 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/canonical"
+	"github.com/ryan-gang/kindle-send-daemon/internal/types"
+)
+
+func openTestStore(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBoltStore(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "state.db"))
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	old := ProcessedBookmark{URL: "https://example.com/old", Hash: "old", Timestamp: now.AddDate(0, 0, -40), Status: types.StatusSent}
+	recent := ProcessedBookmark{URL: "https://example.com/new", Hash: "new", Timestamp: now, Status: types.StatusSent,
+		CanonicalURL: "https://example.org/new", CanonicalHash: "new-canonical"}
+	if err := store.Commit(now, old, recent); err != nil {
+		t.Fatal(err)
+	}
+
+	got, found, err := store.Get("new")
+	if err != nil || !found || got.URL != recent.URL {
+		t.Fatalf("Get(new) = %+v, %v, %v", got, found, err)
+	}
+	if got, found, _ := store.Get("new-canonical"); !found || got.Hash != "new" {
+		t.Errorf("canonical alias found %v, %+v", found, got)
+	}
+	if _, found, _ := store.Get("missing"); found {
+		t.Error("missing hash was found")
+	}
+	if lastCheck, err := store.LastCheck(); err != nil || !lastCheck.Equal(now) {
+		t.Errorf("LastCheck = %s, %v, want %s", lastCheck, err, now)
+	}
+
+	var since []string
+	err = store.ForEachSince(now.AddDate(0, 0, -30), func(bookmark ProcessedBookmark) error {
+		since = append(since, bookmark.Hash)
+		return nil
+	})
+	if err != nil || len(since) != 1 || since[0] != "new" {
+		t.Errorf("ForEachSince = %v, %v, want only new", since, err)
+	}
+
+	// Committing a bookmark again moves it in the timeline rather than listing it twice
+	old.Timestamp = now.AddDate(0, 0, -45)
+	if err := store.Commit(time.Time{}, old); err != nil {
+		t.Fatal(err)
+	}
+	since = nil
+	err = store.ForEachSince(time.Time{}, func(bookmark ProcessedBookmark) error {
+		since = append(since, bookmark.Hash)
+		return nil
+	})
+	if err != nil || len(since) != 2 || since[0] != "old" {
+		t.Errorf("ForEachSince(zero) = %v, %v, want old then new", since, err)
+	}
+
+	pruned, err := store.Prune(now.AddDate(0, 0, -30))
+	if err != nil || pruned != 1 {
+		t.Fatalf("Prune = %d, %v, want 1", pruned, err)
+	}
+	if _, found, _ := store.Get("old"); found {
+		t.Error("pruned bookmark is still there")
+	}
+
+	if deleted, err := store.Delete("new"); err != nil || !deleted {
+		t.Fatalf("Delete = %v, %v", deleted, err)
+	}
+	if _, found, _ := store.Get("new-canonical"); found {
+		t.Error("alias outlived its bookmark")
+	}
+	if deleted, _ := store.Delete("new"); deleted {
+		t.Error("deleting twice reported a bookmark")
+	}
+}
+
+func TestMigrateJSONState(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "processed_bookmarks.json")
+	storePath := filepath.Join(dir, "processed_bookmarks.db")
+	lastCheck := time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC)
+
+	bp := &BookmarkProcessor{statePath: jsonPath, storePath: storePath, canonical: canonical.NewCanonicalizer(canonical.Rules{})}
+	sent := ProcessedBookmark{URL: "https://example.com/a", Hash: bp.hashBookmark("https://example.com/a"), Timestamp: lastCheck}
+	// Written before urls were canonicalized, rehashed on the way in
+	legacy := ProcessedBookmark{URL: "https://www.example.com/b/?utm_source=feed", Hash: hashURL("https://www.example.com/b/?utm_source=feed"), Timestamp: lastCheck}
+	data, err := json.Marshal(ProcessedState{Bookmarks: []ProcessedBookmark{sent, legacy}, LastCheck: lastCheck})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jsonPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(store StateStore) int {
+		n := 0
+		store.ForEach(func(ProcessedBookmark) error { n++; return nil })
+		return n
+	}
+	err = bp.withStore(func(store StateStore) error {
+		if n := count(store); n != 2 {
+			t.Errorf("%d bookmarks after migration, want 2", n)
+		}
+		if _, found, _ := store.Get(bp.hashBookmark("https://example.com/b")); !found {
+			t.Error("legacy entry was not moved to its canonical hash")
+		}
+		if got, _ := store.LastCheck(); !got.Equal(lastCheck) {
+			t.Errorf("last check is %s, want %s", got, lastCheck)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(jsonPath); !os.IsNotExist(err) {
+		t.Error("JSON state was left in place")
+	}
+	if _, err := os.Stat(jsonPath + ".migrated"); err != nil {
+		t.Errorf("JSON state was not renamed: %v", err)
+	}
+
+	// Reopening finds the migrated entries and nothing to import again
+	err = bp.withStore(func(store StateStore) error {
+		if n := count(store); n != 2 {
+			t.Errorf("%d bookmarks after reopening, want 2", n)
+		}
+		if _, found, _ := store.Get(sent.Hash); !found {
+			t.Error("migrated bookmark is gone after reopening")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateJSONStateKeepsNewerEntries(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "processed_bookmarks.json")
+	store := openTestStore(t, filepath.Join(dir, "state.db"))
+
+	newer := ProcessedBookmark{URL: "https://example.com/a", Hash: "a", Timestamp: time.Now(), Status: types.StatusMailFailed}
+	if err := store.Commit(time.Time{}, newer); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(ProcessedState{Bookmarks: []ProcessedBookmark{{URL: newer.URL, Hash: "a", Timestamp: time.Now().AddDate(-1, 0, 0)}}})
+	if err := os.WriteFile(jsonPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := migrateJSONState(jsonPath, store)
+	if err != nil || migrated != 0 {
+		t.Fatalf("migrated %d, %v, want 0", migrated, err)
+	}
+	if got, _, _ := store.Get("a"); got.Status != types.StatusMailFailed {
+		t.Errorf("store entry was overwritten by the JSON one: %+v", got)
+	}
+	if migrated, err := migrateJSONState(filepath.Join(dir, "absent.json"), store); err != nil || migrated != 0 {
+		t.Errorf("missing JSON file migrated %d, %v", migrated, err)
+	}
+}
+
+func TestRehashOnlyWhenRulesChange(t *testing.T) {
+	dir := t.TempDir()
+	bp := &BookmarkProcessor{statePath: filepath.Join(dir, "absent.json"), storePath: filepath.Join(dir, "state.db"),
+		canonical: canonical.NewCanonicalizer(canonical.Rules{})}
+	stale := ProcessedBookmark{URL: "https://example.com/a?campaign=x", Hash: "stale", Timestamp: time.Now()}
+
+	err := bp.withStore(func(store StateStore) error {
+		// Written after the store was rehashed for the current rules
+		return store.Commit(time.Time{}, stale)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bp.withStore(func(store StateStore) error {
+		if _, found, _ := store.Get("stale"); !found {
+			t.Error("store was rehashed although the rules did not change")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bp.canonical = canonical.NewCanonicalizer(canonical.Rules{StripParams: []string{"campaign"}})
+	err = bp.withStore(func(store StateStore) error {
+		if _, found, _ := store.Get("stale"); found {
+			t.Error("store was not rehashed after the rules changed")
+		}
+		if _, found, _ := store.Get(bp.hashBookmark("https://example.com/a")); !found {
+			t.Error("entry is missing under its new hash")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
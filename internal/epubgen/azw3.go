@@ -0,0 +1,82 @@
+package epubgen
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/leotaku/mobi"
+	"github.com/leotaku/mobi/records"
+	"golang.org/x/text/language"
+)
+
+// azw3Output writes books as KF8, the format Kindles read natively. The book is only assembled when written.
+type azw3Output struct {
+	book mobi.Book
+}
+
+func newAZW3Output(title string, stylesheet []byte) *azw3Output {
+	out := &azw3Output{book: mobi.Book{Title: title, CreatedDate: time.Now()}}
+	if len(stylesheet) > 0 {
+		out.book.CSSFlows = []string{string(stylesheet)}
+	}
+	return out
+}
+
+// addImage decodes the prepared image, the writer stores every image as JPEG. Chapters refer to images by their
+// position in the book.
+func (o *azw3Output) addImage(_ string, img embeddedImage) (string, error) {
+	decoded, _, err := decodeImage(img.data)
+	if err != nil {
+		return "", err
+	}
+	o.book.Images = append(o.book.Images, flatten(decoded))
+	return fmt.Sprintf("kindle:embed:%s?mime=image/jpeg", records.To32(len(o.book.Images))), nil
+}
+
+func (o *azw3Output) setCover(img embeddedImage) error {
+	decoded, _, err := decodeImage(img.data)
+	if err != nil {
+		return err
+	}
+	o.book.CoverImage = flatten(decoded)
+	return nil
+}
+
+func (o *azw3Output) addPage(title, _ string, body string) error {
+	o.book.Chapters = append(o.book.Chapters, mobi.Chapter{Title: title, Chunks: mobi.Chunks(body)})
+	return nil
+}
+
+// linksPages is false, KF8 links point at byte offsets in the book's text which the writer doesn't expose
+func (o *azw3Output) linksPages() bool {
+	return false
+}
+
+func (o *azw3Output) write(path string, meta bookMetadata) error {
+	book := o.book
+	if meta.author != "" {
+		book.Authors = []string{meta.author}
+	}
+	book.Language = language.Make(meta.lang)
+	book.PublishedDate = meta.date
+	book.UniqueID = azw3ID(meta.identifier)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := book.Realize().Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// azw3ID shortens the book identifier to the 32 bits KF8 keeps, so the same links still make the same book
+func azw3ID(identifier string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(identifier))
+	return h.Sum32()
+}
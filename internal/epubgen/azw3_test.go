@@ -0,0 +1,70 @@
+package epubgen
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestAZW3Output(t *testing.T) {
+	out := newAZW3Output("Native", []byte("p { margin: 0 }"))
+	maker := &bookmaker{out: out, format: FormatAZW3, layout: loadLayout("")}
+	chapters := []chapter{
+		{url: "https://example.com/a", article: readability.Article{Title: "First", Content: "<p>a</p>"}},
+		{url: "https://example.com/b", article: readability.Article{Title: "Second", Content: "<p>b</p>"}},
+	}
+	failed := []fetched{{url: "https://example.net/missing", err: errors.New("404 Not Found")}}
+	if err := maker.addContent("Native", chapters, failed); err != nil {
+		t.Fatal(err)
+	}
+
+	var titles []string
+	for _, c := range out.book.Chapters {
+		titles = append(titles, c.Title)
+	}
+	if len(titles) != 3 || titles[0] != "First" || titles[2] != "Not included" {
+		t.Errorf("chapters are %q, want the articles and the failed pages without a contents page", titles)
+	}
+
+	for i, want := range []string{"kindle:embed:0001?mime=image/jpeg", "kindle:embed:0002?mime=image/jpeg"} {
+		ref, err := out.addImage("", embeddedImage{data: pngBytes(t, 8), mime: "image/png"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref != want {
+			t.Errorf("image %d is referenced as %q, want %q", i+1, ref, want)
+		}
+	}
+	if err := out.setCover(embeddedImage{data: pngBytes(t, 16), mime: "image/png"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "native.azw3")
+	meta := bookMetadata{author: "Ann", lang: "en", identifier: bookIdentifier([]string{"https://example.com/a"}), date: time.Now()}
+	if err := out.write(path, meta); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 68 || string(data[60:68]) != "BOOKMOBI" {
+		t.Error("written file is not a mobi database")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"": FormatEPUB, "EPUB": FormatEPUB, "azw3": FormatAZW3, " kf8 ": FormatAZW3} {
+		got, err := ParseFormat(name)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("docx"); err == nil {
+		t.Error("unknown format was accepted")
+	}
+}
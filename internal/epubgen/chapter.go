@@ -0,0 +1,77 @@
+package epubgen
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"net/url"
+	"strings"
+
+	"github.com/go-shiori/go-readability"
+)
+
+const wordsPerMinute = 230
+
+// chapter is an article of the book along with the url it was fetched from
+type chapter struct {
+	url     string
+	article readability.Article
+	// localDir is the folder of the local document the chapter was read from, the only place its images may be
+	// read from disk. It is empty for pages fetched from the web.
+	localDir string
+}
+
+// chapterPage holds what the chapter template can show
+type chapterPage struct {
+	Title          string
+	Byline         string
+	SiteName       string
+	URL            string
+	Host           string
+	Published      string
+	ReadingMinutes int
+	Content        template.HTML
+}
+
+// render returns the XHTML body of a chapter
+func (l *layout) render(c chapter) (string, error) {
+	var buf bytes.Buffer
+	if err := l.chapter.Execute(&buf, newChapterPage(c)); err != nil {
+		return "", fmt.Errorf("rendering chapter: %w", err)
+	}
+	return buf.String(), nil
+}
+
+func newChapterPage(c chapter) chapterPage {
+	article := c.article
+	page := chapterPage{
+		Title:          article.Title,
+		Byline:         strings.TrimSpace(article.Byline),
+		SiteName:       strings.TrimSpace(article.SiteName),
+		URL:            c.url,
+		ReadingMinutes: readingMinutes(article.TextContent),
+		// Content was produced by readability and goquery, it is already sanitized HTML
+		Content: template.HTML(article.Content),
+	}
+	if strings.EqualFold(page.Byline, page.SiteName) {
+		page.SiteName = ""
+	}
+	if parsed, err := url.Parse(c.url); err == nil {
+		page.Host = strings.TrimPrefix(parsed.Hostname(), "www.")
+		// Local documents have no page to link back to
+		if parsed.Scheme == "file" {
+			page.URL = ""
+		}
+	}
+	if article.PublishedTime != nil {
+		page.Published = article.PublishedTime.Format("2 January 2006")
+	}
+	return page
+}
+
+// readingMinutes estimates how long the text takes to read, never less than a minute
+func readingMinutes(text string) int {
+	words := len(strings.Fields(text))
+	return max(1, int(math.Round(float64(words)/wordsPerMinute)))
+}
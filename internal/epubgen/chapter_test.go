@@ -0,0 +1,71 @@
+package epubgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestRenderChapter(t *testing.T) {
+	published := time.Date(2024, time.March, 5, 10, 0, 0, 0, time.UTC)
+	c := chapter{
+		url: "https://www.example.com/posts/1?a=1&b=2",
+		article: readability.Article{
+			Title:         "Tom & Jerry",
+			Byline:        "Jane Doe",
+			SiteName:      "Example",
+			PublishedTime: &published,
+			TextContent:   strings.Repeat("word ", 700),
+			Content:       "<p>Body <em>text</em></p>",
+		},
+	}
+
+	body, err := loadLayout("").render(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<h1 class=\"chapter-title\">Tom &amp; Jerry</h1>",
+		"Jane Doe", "Example", "5 March 2024", "3 min read",
+		`<a href="https://www.example.com/posts/1?a=1&amp;b=2">example.com</a>`,
+		"<p>Body <em>text</em></p>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("chapter is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestLayoutOverrides(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, chapterTemplateFile), []byte(`<h2>{{.Title}}</h2>{{.Content}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, stylesheetFile), []byte("body { margin: 0 }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := loadLayout(dir)
+	body, err := l.render(chapter{article: readability.Article{Title: "Custom", Content: "<p>x</p>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "<h2>Custom</h2><p>x</p>" {
+		t.Errorf("override template not used, got %q", body)
+	}
+	if string(l.stylesheet) != "body { margin: 0 }" {
+		t.Errorf("override stylesheet not used, got %q", l.stylesheet)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, chapterTemplateFile), []byte(`{{.Title`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	body, err = loadLayout(dir).render(chapter{article: readability.Article{Title: "Broken"}})
+	if err != nil || !strings.Contains(body, "chapter-title") {
+		t.Errorf("broken override should fall back to the bundled template, got %q, %v", body, err)
+	}
+}
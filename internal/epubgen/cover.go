@@ -0,0 +1,84 @@
+package epubgen
+
+import (
+	"context"
+	"image"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/cover"
+	"github.com/ryan-gang/kindle-send-daemon/internal/util"
+)
+
+// addCover draws a cover for the book and sets it, the lead image of the first article that has one is downloaded
+// when the layout uses it. A book without a cover is still a valid book, so failures are only reported.
+func (m *bookmaker) addCover(ctx context.Context, title string, chapters []chapter, layout cover.Layout) {
+	var lead image.Image
+	if layout.LeadImage {
+		lead = m.leadImage(ctx, chapters)
+	}
+
+	info := cover.Info{Title: title, Domains: domains(chapters), Date: time.Now(), Articles: len(chapters)}
+	img := m.images.profile.Apply(cover.Render(info, layout, lead))
+	encoded, err := encodeJPEG(img, jpegQuality)
+	if err != nil {
+		util.Red.Printf("Couldn't encode cover : %s\n", err)
+		return
+	}
+
+	if err := m.out.setCover(encoded); err != nil {
+		util.Red.Printf("Couldn't add cover : %s\n", err)
+	}
+}
+
+// leadImage returns the first lead image that downloads and decodes, or nil
+func (m *bookmaker) leadImage(ctx context.Context, chapters []chapter) image.Image {
+	for _, c := range chapters {
+		if c.article.Image == "" {
+			continue
+		}
+		src := c.article.Image
+		if base, err := url.Parse(c.url); err == nil {
+			if resolved, err := base.Parse(src); err == nil {
+				src = resolved.String()
+			}
+		}
+
+		data, err := m.images.download(ctx, src, c.localDir)
+		if err != nil {
+			util.Red.Printf("Couldn't download cover image %s : %s\n", src, err)
+			continue
+		}
+		img, _, err := decodeImage(data)
+		if err != nil {
+			util.Red.Printf("Couldn't use cover image %s : %s\n", src, err)
+			continue
+		}
+		return img
+	}
+	return nil
+}
+
+// domains lists the sites of the chapters in order, each once
+func domains(chapters []chapter) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, c := range chapters {
+		host := chapterHost(c)
+		if host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// chapterHost is the site a chapter comes from without its www prefix, "" when the url has no host
+func chapterHost(c chapter) string {
+	parsed, err := url.Parse(c.url)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(parsed.Hostname(), "www.")
+}
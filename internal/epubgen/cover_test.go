@@ -0,0 +1,56 @@
+package epubgen
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+	"github.com/ryan-gang/kindle-send-daemon/internal/cover"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+)
+
+func TestAddCover(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path == "/lead.png"
+		w.Write(pngBytes(t, 16))
+	}))
+	defer server.Close()
+
+	out := newEpubOutput("Cover", nil)
+	book := out.book
+	maker := &bookmaker{out: out, format: FormatEPUB, images: newImagePipeline(out, 1, 0, imaging.Profiles["kindle"])}
+	chapters := []chapter{
+		{url: server.URL + "/posts/1", article: readability.Article{Title: "One"}},
+		{url: server.URL + "/posts/2", article: readability.Article{Title: "Two", Image: "/lead.png"}},
+	}
+
+	layout := cover.DefaultLayout()
+	layout.Width, layout.Height, layout.LeadImage = 300, 400, true
+	maker.addCover(context.Background(), "Cover", chapters, layout)
+	if !requested {
+		t.Error("lead image of the second article was not downloaded")
+	}
+
+	if _, err := book.AddSection("<p>text</p>", "One", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Write(filepath.Join(t.TempDir(), "cover.epub")); err != nil {
+		t.Fatalf("writing epub with a cover: %v", err)
+	}
+}
+
+func TestDomains(t *testing.T) {
+	got := domains([]chapter{
+		{url: "https://www.example.com/a"},
+		{url: "https://blog.example.org/b"},
+		{url: "https://example.com/c"},
+		{url: "not a url"},
+	})
+	if len(got) != 2 || got[0] != "example.com" || got[1] != "blog.example.org" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -1,278 +1,281 @@
 package epubgen
 
 import (
-	"bytes"
-	"errors"
-	"image"
-	"image/jpeg"
-	"image/png"
-	"io"
-	"net/http"
+	"context"
+	"fmt"
 	"os"
 	"path"
-	"path/filepath"
-	"strings"
-	"sync"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/bmaupin/go-epub"
-	"github.com/go-shiori/go-readability"
 	"github.com/gosimple/slug"
 	"github.com/ryan-gang/kindle-send-daemon/internal/config"
+	"github.com/ryan-gang/kindle-send-daemon/internal/cover"
+	"github.com/ryan-gang/kindle-send-daemon/internal/simhash"
 	"github.com/ryan-gang/kindle-send-daemon/internal/util"
 )
 
-type epubmaker struct {
-	Epub      *epub.Epub
-	downloads map[string]string
+// Book describes a generated book
+type Book struct {
+	Path  string
+	Title string
+	// Fingerprint identifies the text of a single article book, it is 0 for books made of several articles
+	Fingerprint simhash.Fingerprint
 }
 
-func NewEpubmaker(title string) *epubmaker {
-	downloadMap := make(map[string]string)
-	return &epubmaker{
-		Epub:      epub.NewEpub(title),
-		downloads: downloadMap,
-	}
+// bookmaker turns the fetched articles into a book, the format specific work is left to its output
+type bookmaker struct {
+	out    output
+	format Format
+	images *imagePipeline
+	layout *layout
+	// coverLayout is nil when covers are disabled
+	coverLayout *cover.Layout
 }
 
-func fetchReadable(url string) (readability.Article, error) {
-	return readability.FromURL(url, 30*time.Second)
+func NewBookmaker(title string, format Format) *bookmaker {
+	cfg := config.GetInstance()
+	layout := loadLayout(cfg.ConfigDir)
+	profile := cfg.ImageProfile.Profile()
+	out := newOutput(format, title, layout.stylesheet, profile)
+	maker := &bookmaker{
+		out:    out,
+		format: format,
+		images: newImagePipeline(out, cfg.ImageConcurrency, int64(cfg.MaxImageKB)*1024, profile),
+		layout: layout,
+	}
+
+	if !cfg.Cover.Disabled {
+		coverLayout := cfg.Cover.Layout(profile.MaxWidth, profile.MaxHeight)
+		maker.coverLayout = &coverLayout
+	}
+	return maker
 }
 
-// Point remote image link to downloaded image
-func (e *epubmaker) changeRefs(i int, img *goquery.Selection) {
-	img.RemoveAttr("loading")
-	img.RemoveAttr("srcset")
-	imgSrc, exists := img.Attr("src")
-	if exists {
-		if _, ok := e.downloads[imgSrc]; ok {
-			util.Green.Printf("Setting img src from %s to %s \n", imgSrc, e.downloads[imgSrc])
-			img.SetAttr("src", e.downloads[imgSrc])
+// renderable drops the chapters the layout fails to render and adds them to failed, so the contents page and
+// links between articles only ever point at chapters that make it into the book
+func (m *bookmaker) renderable(chapters []chapter, failed []fetched) ([]chapter, []fetched) {
+	kept := make([]chapter, 0, len(chapters))
+	for _, c := range chapters {
+		if _, err := m.layout.render(c); err != nil {
+			util.Red.Printf("Couldn't add %s to %s : %s\n", c.article.Title, m.format, err)
+			failed = append(failed, fetched{url: c.url, err: err})
+			continue
 		}
+		kept = append(kept, c)
 	}
+	return kept, failed
 }
 
-// compressImage compresses the image data based on its type
-func compressImage(imgData []byte, imgURL string) ([]byte, error) {
-	// Determine image type from URL or content
-	imgType := strings.ToLower(filepath.Ext(imgURL))
-
-	// Decode the image
-	img, format, err := image.Decode(bytes.NewReader(imgData))
-	if err != nil {
-		return nil, err
+// Add chapters to the book. Books made from several urls open with a contents page, and end with the list of
+// pages that could not be fetched when there are any. Formats whose pages can't link to each other rely on the
+// reader's own table of contents instead. Chapters should have gone through renderable first, one that still
+// can't be added is listed with the pages that could not be fetched.
+func (m *bookmaker) addContent(title string, chapters []chapter, failed []fetched) error {
+	digest := len(chapters)+len(failed) > 1
+	if digest && m.out.linksPages() {
+		body, err := m.layout.renderContents(title, chapters, failed)
+		m.addPage(body, err, "Contents", contentsFile)
 	}
 
-	var buf bytes.Buffer
+	added := 0
+	for i, c := range chapters {
+		body, err := m.layout.render(c)
+		if err := m.addPage(body, err, c.article.Title, chapterFile(i)); err != nil {
+			failed = append(failed, fetched{url: c.url, err: err})
+			continue
+		}
+		added++
+	}
+	util.Green.Printf("Added %d articles\n", added)
+	if added == 0 {
+		return fmt.Errorf("no article was added, %s creation failed", m.format)
+	}
 
-	// Compress based on format
-	if format == "jpeg" || imgType == ".jpg" || imgType == ".jpeg" {
-		// JPEG quality settings (0-100), 85 is a good balance
-		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
-	} else if format == "png" || imgType == ".png" {
-		// For PNG, using default compression
-		encoder := png.Encoder{CompressionLevel: png.DefaultCompression}
-		err = encoder.Encode(&buf, img)
-	} else {
-		// For other formats, just return the original data
-		return imgData, nil
+	if digest && len(failed) > 0 {
+		body, err := m.layout.renderFailed(failed)
+		m.addPage(body, err, "Not included", failedFile)
 	}
+	return nil
+}
 
+// addPage adds a rendered page as a section of the book, returning why it couldn't be added
+func (m *bookmaker) addPage(body string, renderErr error, title, filename string) error {
+	err := renderErr
+	if err == nil {
+		err = m.out.addPage(title, filename, body)
+	}
 	if err != nil {
-		return nil, err
+		util.Red.Printf("Couldn't add %s to %s : %s\n", title, m.format, err)
 	}
-
-	return buf.Bytes(), nil
+	return err
 }
 
-// Download images and add to epub zip
-func (e *epubmaker) downloadImages(i int, img *goquery.Selection) {
-	util.CyanBold.Println("Downloading Images")
-	imgSrc, exists := img.Attr("src")
-
-	if exists {
-		// don't download same thing twice
-		if _, ok := e.downloads[imgSrc]; ok {
-			return
-		}
-
-		// pass unique and safe image names here, then it will not crash on windows
-		// use murmur hash to generate file name
-		imageFileName := util.GetHash(imgSrc)
-
-		// Download the image data first
-		resp, err := http.Get(imgSrc)
-		if err != nil {
-			util.Red.Printf("Couldn't download image %s: %s\n", imgSrc, err)
-			return
-		}
-		defer resp.Body.Close()
-
-		// Read the image data
-		imgData, err := io.ReadAll(resp.Body)
-		if err != nil {
-			util.Red.Printf("Error reading image data from %s: %s\n", imgSrc, err)
-			return
-		}
-
-		// Compress the image
-		compressedImgData, err := compressImage(imgData, imgSrc)
-		if err != nil {
-			util.Red.Printf("Error compressing image %s: %s\n", imgSrc, err)
-			// Fallback to original image if compression fails
-			compressedImgData = imgData
-		} else {
-			originalSize := len(imgData)
-			compressedSize := len(compressedImgData)
-			reduction := float64(originalSize-compressedSize) / float64(originalSize) * 100
-			util.Green.Printf("Image %s compressed: %d KB → %d KB (%.1f%% reduction)\n",
-				filepath.Base(imgSrc), originalSize/1024, compressedSize/1024, reduction)
-		}
-
-		// Create a temp file for the compressed image
-		tempFile, err := os.CreateTemp("", "kindle-send-img-*"+filepath.Ext(imageFileName))
-		if err != nil {
-			util.Red.Printf("Couldn't create temp file for image %s: %s\n", imgSrc, err)
-			return
-		}
-		defer tempFile.Close()
-
-		// Write compressed data to temp file
-		if _, err := tempFile.Write(compressedImgData); err != nil {
-			util.Red.Printf("Error writing to temp file for image %s: %s\n", imgSrc, err)
-			os.Remove(tempFile.Name())
-			return
-		}
-
-		// Create a custom URL for the temp file to use with AddImage
-		tempFileURL := "file://" + tempFile.Name()
-
-		// Add the compressed image to epub using the URL approach
-		imgRef, err := e.Epub.AddImage(tempFileURL, imageFileName)
-		if err != nil {
-			util.Red.Printf("Couldn't add image %s : %s\n", imgSrc, err)
-			os.Remove(tempFile.Name())
-			return
-		}
-
-		// Clean up temp file after it's added to the epub
-		defer os.Remove(tempFile.Name())
+// epubOutput writes books as epub through go-epub
+type epubOutput struct {
+	book *epub.Epub
+	// css is the path of the chapter stylesheet in the epub, empty if it couldn't be added
+	css string
+}
 
-		util.Green.Printf("Downloaded and compressed image %s\n", imgSrc)
-		e.downloads[imgSrc] = imgRef
+func newEpubOutput(title string, stylesheet []byte) *epubOutput {
+	out := &epubOutput{book: epub.NewEpub(title)}
+	if len(stylesheet) == 0 {
+		return out
 	}
+	css, err := out.book.AddCSS(dataURL("text/css", stylesheet), stylesheetFile)
+	if err != nil {
+		util.Red.Printf("Couldn't add stylesheet, chapters will use the reader's defaults : %s\n", err)
+	}
+	out.css = css
+	return out
 }
 
-// Fetches images in article and then embeds them into epub
-func (e *epubmaker) embedImages(wg *sync.WaitGroup, article *readability.Article) {
-	util.Cyan.Println("Embedding images in ", article.Title)
-	defer wg.Done()
-	// Compression is now handled in downloadImages function
-	doc := goquery.NewDocumentFromNode(article.Node)
-
-	//download all images
-	doc.Find("img").Each(e.downloadImages)
-
-	//Change all refs, doing it in two phases to download repeated images only once
-	doc.Find("img").Each(e.changeRefs)
-
-	content, err := doc.Html()
+func (o *epubOutput) addImage(name string, img embeddedImage) (string, error) {
+	return o.book.AddImage(dataURL(img.mime, img.data), name)
+}
 
+func (o *epubOutput) setCover(img embeddedImage) error {
+	ref, err := o.book.AddImage(dataURL(img.mime, img.data), "cover"+img.ext())
 	if err != nil {
-		util.Red.Printf("Error converting modified %s to HTML, it will be transferred without images : %s \n", article.Title, err)
-	} else {
-		article.Content = content
+		return err
 	}
+	o.book.SetCover(ref, "")
+	return nil
 }
 
-// TODO: Look for better formatting, this is bare bones
-func prepare(article *readability.Article) string {
-	return "<h1>" + article.Title + "</h1>" + article.Content
+func (o *epubOutput) addPage(title, filename, body string) error {
+	_, err := o.book.AddSection(body, title, filename, o.css)
+	return err
 }
 
-// Add articles to epub
-func (e *epubmaker) addContent(articles *[]readability.Article) error {
-	added := 0
-	for _, article := range *articles {
-		_, err := e.Epub.AddSection(prepare(&article), article.Title, "", "")
-		if err != nil {
-			util.Red.Printf("Couldn't add %s to epub : %s", article.Title, err)
-		} else {
-			added++
-		}
+func (o *epubOutput) linksPages() bool {
+	return true
+}
+
+func (o *epubOutput) write(path string, meta bookMetadata) error {
+	meta.apply(o.book)
+	if err := o.book.Write(path); err != nil {
+		return err
 	}
-	util.Green.Printf("Added %d articles\n", added)
-	if added == 0 {
-		return errors.New("no article was added, epub creation failed")
+	if err := addPackageMetadata(path, meta); err != nil {
+		util.Red.Printf("Couldn't add publication date and sources to %s : %s\n", path, err)
 	}
 	return nil
 }
 
-// Make : Generates a single epub from a slice of urls, returns file path
-func Make(pageUrls []string, title string) (string, error) {
-	//TODO: Parallelize fetching pages
+// Make : Generates a single book in format from a slice of urls, returns the written book. Export formats
+// write a file per article instead, see exportArticles.
+// Chapters keep the order of pageUrls, cancelling ctx aborts the pages still being fetched.
+func Make(ctx context.Context, pageUrls []string, title string, format Format) (Book, error) {
+	cfg := config.GetInstance()
+	pages := newFetcher(cfg.FetchConcurrency, time.Duration(cfg.FetchHostDelayMs)*time.Millisecond, cfg.ImageProfile.Profile().MaxWidth, newExtractors(cfg.Extractors))
 
 	//Get readable article from urls
-	readableArticles := make([]readability.Article, 0)
-	for _, pageUrl := range pageUrls {
-		article, err := fetchReadable(pageUrl)
-		if err != nil {
-			util.Red.Printf("Couldn't convert %s because %s", pageUrl, err)
-			util.Magenta.Println("SKIPPING ", pageUrl)
+	chapters := make([]chapter, 0)
+	var failed []fetched
+	for _, page := range pages.fetchAll(ctx, pageUrls) {
+		if page.err != nil {
+			util.Red.Printf("Couldn't convert %s because %s\n", page.url, page.err)
+			util.Magenta.Println("SKIPPING ", page.url)
+			failed = append(failed, page)
 			continue
 		}
-		util.Green.Printf("Fetched %s --> %s\n", pageUrl, article.Title)
-		readableArticles = append(readableArticles, article)
+		util.Green.Printf("Fetched %s --> %s\n", page.url, page.article.Title)
+		chapters = append(chapters, chapter{url: page.url, article: page.article})
+	}
+	if err := ctx.Err(); err != nil {
+		return Book{}, err
 	}
 
-	if len(readableArticles) == 0 {
-		return "", errors.New("no readable url given, exiting without creating epub")
+	if len(chapters) == 0 {
+		return Book{}, fmt.Errorf("no readable url given, exiting without creating %s", format)
 	}
+	return build(ctx, title, chapters, failed, format)
+}
+
+// MakeLocal : Generates a book in format from a local HTML, Markdown or text file, returns the written book
+func MakeLocal(ctx context.Context, filePath string, format Format) (Book, error) {
+	c, err := readLocal(filePath, config.GetInstance().ImageProfile.Profile().MaxWidth)
+	if err != nil {
+		return Book{}, fmt.Errorf("couldn't read %s: %w", filePath, err)
+	}
+	util.Green.Printf("Read %s --> %s\n", filePath, c.article.Title)
+	return build(ctx, "", []chapter{c}, nil, format)
+}
 
+// build writes the chapters as a book, or exports them, titled after the first chapter when title is empty
+func build(ctx context.Context, title string, chapters []chapter, failed []fetched, format Format) (Book, error) {
 	if len(title) == 0 {
-		title = readableArticles[0].Title
+		title = chapters[0].article.Title
 		util.Magenta.Printf("No title supplied, inheriting title of first readable article : %s \n", title)
 	}
 
-	book := NewEpubmaker(title)
+	storeDir := storeDirectory()
+	var bookPath string
+	var err error
+	if format.Export() {
+		bookPath, err = exportArticles(ctx, title, chapters, storeDir, format)
+	} else {
+		bookPath = path.Join(storeDir, fileSlug(title, chapters[0])+format.Ext())
+		err = writeBook(ctx, title, chapters, failed, bookPath, format)
+	}
+	if err != nil {
+		return Book{}, err
+	}
+	result := Book{Path: bookPath, Title: title}
+	if len(chapters) == 1 {
+		result.Fingerprint = simhash.Of(chapters[0].article.TextContent)
+	}
+	return result, nil
+}
 
-	//get images and embed them
-	var wg sync.WaitGroup
+// writeBook makes the chapters into a book in format and writes it to bookPath
+func writeBook(ctx context.Context, title string, chapters []chapter, failed []fetched, bookPath string, format Format) error {
+	cfg := config.GetInstance()
+	book := NewBookmaker(title, format)
 
-	for i := 0; i < len(readableArticles); i++ {
-		wg.Add(1)
-		go book.embedImages(&wg, &readableArticles[i])
+	chapters, failed = book.renderable(chapters, failed)
+	if len(chapters) == 0 {
+		return fmt.Errorf("no article was added, %s creation failed", format)
 	}
 
-	wg.Wait()
+	//get images and embed them
+	book.images.embed(ctx, chapters)
+	// Links between articles stay on the web when the format has no way to point at another page
+	linked := chapters
+	if !book.out.linksPages() {
+		linked = nil
+	}
+	newLinkRewriter(linked, cfg.Canonicalization.Rules(), cfg.LinkFootnotes).rewrite(chapters)
 
-	err := book.addContent(&readableArticles)
-	if err != nil {
-		return "", err
+	if book.coverLayout != nil {
+		book.addCover(ctx, title, chapters, *book.coverLayout)
 	}
-	var storeDir string
-	if len(config.GetInstance().StorePath) == 0 {
-		storeDir, err = os.Getwd()
-		if err != nil {
-			util.Red.Println("Error getting current directory, trying fallback")
-			storeDir = "./"
-		}
-	} else {
-		storeDir = config.GetInstance().StorePath
+
+	if err := book.addContent(title, chapters, failed); err != nil {
+		return err
 	}
+	return book.out.write(bookPath, newBookMetadata(chapters, time.Now()))
+}
 
-	titleSlug := slug.Make(title)
-	var filename string
-	if len(titleSlug) == 0 {
-		filename = "kindle-send-doc-" + util.GetHash(readableArticles[0].Content) + ".epub"
-	} else {
-		filename = titleSlug + ".epub"
+// storeDirectory is where generated files are saved, the configured store path or the working directory
+func storeDirectory() string {
+	if storePath := config.GetInstance().StorePath; len(storePath) > 0 {
+		return storePath
 	}
-	filepath := path.Join(storeDir, filename)
-	err = book.Epub.Write(filepath)
+	storeDir, err := os.Getwd()
 	if err != nil {
-		return "", err
+		util.Red.Println("Error getting current directory, trying fallback")
+		return "./"
+	}
+	return storeDir
+}
+
+// fileSlug names the file of title, falling back to a hash of the first article when the title has no usable characters
+func fileSlug(title string, first chapter) string {
+	if titleSlug := slug.Make(title); len(titleSlug) > 0 {
+		return titleSlug
 	}
-	return filepath, nil
+	return "kindle-send-doc-" + util.GetHash(first.article.Content)
 }
@@ -0,0 +1,152 @@
+package epubgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/JohannesKaufmann/html-to-markdown/plugin"
+	"github.com/ryan-gang/kindle-send-daemon/internal/config"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+	"github.com/ryan-gang/kindle-send-daemon/internal/util"
+)
+
+// documentPage holds what the document template can show
+type documentPage struct {
+	Lang  string
+	Title string
+	Style template.CSS
+	Body  template.HTML
+}
+
+// dataURLStore inlines images into the pages that show them, so an exported page needs no other file
+type dataURLStore struct{}
+
+func (dataURLStore) addImage(_ string, img embeddedImage) (string, error) {
+	return dataURL(img.mime, img.data), nil
+}
+
+// exportArticles writes every article to a Markdown or HTML file of its own and returns where they went. A single
+// article is written to storeDir, the articles of a collection to a folder named after its title.
+func exportArticles(ctx context.Context, title string, chapters []chapter, storeDir string, format Format) (string, error) {
+	cfg := config.GetInstance()
+	l := loadLayout(cfg.ConfigDir)
+	if format == FormatHTML {
+		// Exports are read on screens of any size, images are only converted to formats browsers show
+		newImagePipeline(dataURLStore{}, cfg.ImageConcurrency, int64(cfg.MaxImageKB)*1024, imaging.Profile{}).embed(ctx, chapters)
+	}
+
+	dir := storeDir
+	if len(chapters) > 1 {
+		dir = filepath.Join(storeDir, fileSlug(title, chapters[0]))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+
+	var written []string
+	for i, c := range chapters {
+		var data []byte
+		var err error
+		if format == FormatHTML {
+			data, err = l.renderDocument(c)
+		} else {
+			data, err = markdownArticle(c)
+		}
+
+		name := fileSlug(c.article.Title, c) + format.Ext()
+		if len(chapters) > 1 {
+			// Numbered so the files list in the order of the links
+			name = fmt.Sprintf("%02d-%s", i+1, name)
+		}
+		if err == nil && isSource(filepath.Join(dir, name), c) {
+			err = errors.New("the export would replace the document it was made from")
+		}
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name), data, 0644)
+		}
+		if err != nil {
+			util.Red.Printf("Couldn't export %s : %s\n", c.article.Title, err)
+			continue
+		}
+		written = append(written, filepath.Join(dir, name))
+	}
+
+	util.Green.Printf("Exported %d articles\n", len(written))
+	switch {
+	case len(written) == 0:
+		return "", errors.New("no article was exported")
+	case len(chapters) == 1:
+		return written[0], nil
+	default:
+		return dir, nil
+	}
+}
+
+// isSource reports whether target is the local document c was read from
+func isSource(target string, c chapter) bool {
+	abs, err := filepath.Abs(target)
+	return err == nil && c.url == (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// renderDocument returns a standalone HTML page showing the chapter
+func (l *layout) renderDocument(c chapter) ([]byte, error) {
+	body, err := l.render(c)
+	if err != nil {
+		return nil, err
+	}
+	page := documentPage{
+		Lang:  bookLang([]chapter{c}),
+		Title: c.article.Title,
+		// The stylesheet is bundled or placed next to the config by the user, it is trusted
+		Style: template.CSS(l.stylesheet),
+		Body:  template.HTML(body),
+	}
+
+	var buf bytes.Buffer
+	if err := l.document.Execute(&buf, page); err != nil {
+		return nil, fmt.Errorf("rendering document: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// markdownArticle converts an article to Markdown, opening with front matter that records where it came from
+func markdownArticle(c chapter) ([]byte, error) {
+	converter := md.NewConverter("", true, nil)
+	converter.Use(plugin.GitHubFlavored())
+	body, err := converter.ConvertString(c.article.Content)
+	if err != nil {
+		return nil, fmt.Errorf("converting to Markdown: %w", err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	frontMatter(&b, "url", c.url)
+	frontMatter(&b, "title", c.article.Title)
+	if byline := strings.TrimSpace(c.article.Byline); byline != "" {
+		frontMatter(&b, "byline", byline)
+	}
+	if c.article.PublishedTime != nil {
+		fmt.Fprintf(&b, "date: %s\n", c.article.PublishedTime.Format(time.RFC3339))
+	}
+	b.WriteString("---\n\n")
+	if c.article.Title != "" {
+		b.WriteString("# " + c.article.Title + "\n\n")
+	}
+	b.WriteString(strings.TrimSpace(body) + "\n")
+	return b.Bytes(), nil
+}
+
+// frontMatter writes a YAML string field. Go's quoting only uses escapes YAML double quoted strings understand.
+func frontMatter(b *bytes.Buffer, key, value string) {
+	b.WriteString(key + ": " + strconv.Quote(value) + "\n")
+}
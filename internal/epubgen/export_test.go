@@ -0,0 +1,63 @@
+package epubgen
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+)
+
+func TestMarkdownArticle(t *testing.T) {
+	published := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	c := chapter{url: "https://example.com/post", article: readability.Article{
+		Title:         `Say "hello"`,
+		Byline:        "Ann",
+		PublishedTime: &published,
+		Content:       `<p>Some <strong>bold</strong> text and a <a href="https://example.org">link</a>.</p><ul><li>one</li></ul>`,
+	}}
+
+	data, err := markdownArticle(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	want := "---\nurl: \"https://example.com/post\"\ntitle: \"Say \\\"hello\\\"\"\nbyline: \"Ann\"\ndate: 2024-03-01T09:30:00Z\n---\n\n# Say \"hello\"\n\n"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("front matter is\n%s\nwant\n%s", got, want)
+	}
+	for _, part := range []string{"**bold**", "[link](https://example.org)", "- one"} {
+		if !strings.Contains(got, part) {
+			t.Errorf("Markdown is missing %q:\n%s", part, got)
+		}
+	}
+}
+
+func TestRenderDocumentInlinesImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(pngBytes(t, 8))
+	}))
+	defer server.Close()
+
+	chapters := []chapter{parseChapter(t, server.URL, `<p>Text</p><img src="`+server.URL+`/a.png">`)}
+	newImagePipeline(dataURLStore{}, 1, 0, imaging.Profile{}).embed(context.Background(), chapters)
+
+	data, err := loadLayout("").renderDocument(chapters[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	if !strings.Contains(page, `src="data:image/png;base64,`) {
+		t.Error("image was not inlined as a data url")
+	}
+	if strings.Contains(page, server.URL+"/a.png") {
+		t.Error("page still loads the image from the web")
+	}
+	if !strings.HasPrefix(page, "<!DOCTYPE html>") || !strings.Contains(page, ".chapter-title") {
+		t.Errorf("page is not a standalone document with the stylesheet:\n%s", page)
+	}
+}
@@ -0,0 +1,161 @@
+package epubgen
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-shiori/go-readability"
+	"github.com/ryan-gang/kindle-send-daemon/internal/config"
+	"github.com/ryan-gang/kindle-send-daemon/internal/extract"
+	"github.com/ryan-gang/kindle-send-daemon/internal/util"
+)
+
+const pageTimeout = 30 * time.Second
+
+// fetched is the outcome of fetching one page, kept at the index of its url
+type fetched struct {
+	url     string
+	article readability.Article
+	err     error
+}
+
+// fetcher downloads readable articles with a bounded number of workers, spacing out requests to the same host
+type fetcher struct {
+	concurrency int
+	hostDelay   time.Duration
+	client      *http.Client
+	// imageWidth is the screen width srcset candidates are chosen for, 0 uses fallbackImageWidth
+	imageWidth int
+	extractors *extract.Registry
+
+	mu       sync.Mutex
+	nextSlot map[string]time.Time
+}
+
+// newFetcher returns a fetcher extracting articles with extractors, nil uses the built-in rules
+func newFetcher(concurrency int, hostDelay time.Duration, imageWidth int, extractors *extract.Registry) *fetcher {
+	if concurrency <= 0 {
+		concurrency = config.DefaultFetchConcurrency
+	}
+	if hostDelay < 0 {
+		hostDelay = 0
+	}
+	if extractors == nil {
+		extractors = extract.NewRegistry()
+	}
+	return &fetcher{
+		concurrency: concurrency,
+		hostDelay:   hostDelay,
+		imageWidth:  imageWidth,
+		extractors:  extractors,
+		client:      &http.Client{Timeout: pageTimeout},
+		nextSlot:    make(map[string]time.Time),
+	}
+}
+
+// newExtractors returns the built-in extraction rules with the configured ones taking precedence
+func newExtractors(rules []config.ExtractorRule) *extract.Registry {
+	registry := extract.NewRegistry()
+	for _, rule := range rules {
+		selector, err := extract.NewSelector(rule.Content, rule.Remove)
+		if err != nil {
+			util.Red.Printf("Warning: ignoring extractor rule for %s : %s\n", rule.Host, err)
+			continue
+		}
+		registry.Register(rule.Host, selector)
+	}
+	return registry
+}
+
+// fetchAll fetches every url and returns the outcomes in the order of pageUrls.
+// Once ctx is cancelled, pages not yet started fail with the context error.
+func (f *fetcher) fetchAll(ctx context.Context, pageUrls []string) []fetched {
+	results := make([]fetched, len(pageUrls))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(f.concurrency, len(pageUrls)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				article, err := f.fetch(ctx, pageUrls[i])
+				results[i] = fetched{url: pageUrls[i], article: article, err: err}
+			}
+		}()
+	}
+
+	for i := range pageUrls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// fetch downloads a single page and extracts its readable article
+func (f *fetcher) fetch(ctx context.Context, pageURL string) (readability.Article, error) {
+	parsedURL, err := url.ParseRequestURI(pageURL)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to parse URL: %w", err)
+	}
+	if err := f.wait(ctx, parsedURL.Host); err != nil {
+		return readability.Article{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to fetch the page: %w", err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to fetch the page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return readability.Article{}, fmt.Errorf("failed to fetch the page: %s", resp.Status)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return readability.Article{}, fmt.Errorf("URL is not a HTML document")
+	}
+
+	// Images are resolved before the article is extracted, so lazily loaded ones count as real images
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to parse the page: %w", err)
+	}
+	resolveImages(doc, resp.Request.URL, f.imageWidth)
+	return f.extractors.For(resp.Request.URL.Hostname()).Extract(doc, resp.Request.URL)
+}
+
+// wait blocks until the host may be contacted again, reserving the slot after it for the next caller
+func (f *fetcher) wait(ctx context.Context, host string) error {
+	if f.hostDelay == 0 {
+		return ctx.Err()
+	}
+
+	f.mu.Lock()
+	now := time.Now()
+	slot := f.nextSlot[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	f.nextSlot[host] = slot.Add(f.hostDelay)
+	f.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package epubgen
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func articleServer(t *testing.T, inFlight, peak *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(inFlight, 1)
+		defer atomic.AddInt32(inFlight, -1)
+		for {
+			seen := atomic.LoadInt32(peak)
+			if current <= seen || atomic.CompareAndSwapInt32(peak, seen, current) {
+				break
+			}
+		}
+
+		// Earlier pages answer slower, so finishing order differs from request order
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		time.Sleep(time.Duration(10-n) * 5 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<html><head><title>Page %d</title></head><body><article><h1>Page %d</h1>
+			<p>This is the body of page number %d, it has enough words to be picked up as the main content.</p>
+			</article></body></html>`, n, n, n)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchAllKeepsOrderAndBound(t *testing.T) {
+	var inFlight, peak int32
+	server := articleServer(t, &inFlight, &peak)
+
+	var urls []string
+	for i := 0; i < 10; i++ {
+		urls = append(urls, fmt.Sprintf("%s/?n=%d", server.URL, i))
+	}
+
+	results := newFetcher(3, 0, 0, nil).fetchAll(context.Background(), urls)
+	for i, result := range results {
+		if result.err != nil {
+			t.Fatalf("page %d: %v", i, result.err)
+		}
+		if want := fmt.Sprintf("Page %d", i); result.article.Title != want {
+			t.Errorf("result %d has title %q, want %q", i, result.article.Title, want)
+		}
+	}
+	if peak > 3 {
+		t.Errorf("%d pages were fetched at once, want at most 3", peak)
+	}
+}
+
+func TestFetchHostDelay(t *testing.T) {
+	var inFlight, peak int32
+	server := articleServer(t, &inFlight, &peak)
+
+	start := time.Now()
+	newFetcher(4, 40*time.Millisecond, 0, nil).fetchAll(context.Background(), []string{
+		server.URL + "/?n=9", server.URL + "/?n=9", server.URL + "/?n=9",
+	})
+	// Three requests to one host need at least two gaps between them
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("fetched in %s, the per host delay was not applied", elapsed)
+	}
+}
+
+func TestFetchAllCancelled(t *testing.T) {
+	var inFlight, peak int32
+	server := articleServer(t, &inFlight, &peak)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, result := range newFetcher(2, time.Second, 0, nil).fetchAll(ctx, []string{server.URL + "/?n=1", server.URL + "/?n=2"}) {
+		if result.err == nil {
+			t.Errorf("%s was fetched after cancellation", result.url)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package epubgen
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+)
+
+// Format is the kind of file a book is written as
+type Format string
+
+const (
+	FormatEPUB Format = "epub"
+	// FormatAZW3 is Kindle's native KF8 format, for sideloading without Amazon's conversion
+	FormatAZW3 Format = "azw3"
+	// FormatPDF lays books out on pages the size of the device screen
+	FormatPDF Format = "pdf"
+	// FormatMarkdown and FormatHTML export every article to a file of its own, for notes and archives
+	FormatMarkdown Format = "md"
+	FormatHTML     Format = "html"
+)
+
+// Formats lists every output format, the first one is the default
+var Formats = []Format{FormatEPUB, FormatAZW3, FormatPDF, FormatMarkdown, FormatHTML}
+
+// BookFormats are the formats that make a single book an e-reader can open
+var BookFormats = []Format{FormatEPUB, FormatAZW3, FormatPDF}
+
+// MailFormats are the book formats Send-to-Kindle mail accepts, azw3 books can only be copied over USB
+var MailFormats = []Format{FormatEPUB, FormatPDF}
+
+// CheckFormat explains why format can't be used where only formats are allowed, nil when it can
+func CheckFormat(format Format, formats []Format) error {
+	if slices.Contains(formats, format) {
+		return nil
+	}
+	if format == FormatAZW3 {
+		return fmt.Errorf("azw3 books can't be mailed, Send-to-Kindle rejects them; use one of %s, or download azw3 books and copy them over USB", FormatNames(formats))
+	}
+	return fmt.Errorf("format %s is not available here, use one of %s", format, FormatNames(formats))
+}
+
+// ParseFormat reads a format name, an empty name is the default format
+func ParseFormat(name string) (Format, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		return Formats[0], nil
+	case "kf8":
+		return FormatAZW3, nil
+	case "markdown":
+		return FormatMarkdown, nil
+	}
+	for _, format := range Formats {
+		if string(format) == name {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q, use one of %s", name, FormatNames(Formats))
+}
+
+// FormatNames lists formats for help and error messages
+func FormatNames(formats []Format) string {
+	names := make([]string, len(formats))
+	for i, format := range formats {
+		names[i] = string(format)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Ext is the file extension of books in the format
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+// Export reports whether the format writes the articles as separate files instead of a book
+func (f Format) Export() bool {
+	return !slices.Contains(BookFormats, f)
+}
+
+// imageStore keeps the images of a book and tells chapters how to refer to them
+type imageStore interface {
+	addImage(name string, img embeddedImage) (string, error)
+}
+
+// output assembles a book in one format. Pages and images are added as they are made, write produces the file.
+type output interface {
+	imageStore
+	setCover(img embeddedImage) error
+	addPage(title, filename, body string) error
+	// linksPages reports whether pages can link to each other by their file name
+	linksPages() bool
+	write(path string, meta bookMetadata) error
+}
+
+// newOutput starts an empty book in format for the device of profile, styled with stylesheet
+func newOutput(format Format, title string, stylesheet []byte, profile imaging.Profile) output {
+	switch format {
+	case FormatAZW3:
+		return newAZW3Output(title, stylesheet)
+	case FormatPDF:
+		return newPDFOutput(title, profile)
+	default:
+		return newEpubOutput(title, stylesheet)
+	}
+}
@@ -0,0 +1,25 @@
+package epubgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckFormat(t *testing.T) {
+	for _, format := range MailFormats {
+		if err := CheckFormat(format, MailFormats); err != nil {
+			t.Errorf("CheckFormat(%s): %v", format, err)
+		}
+	}
+
+	err := CheckFormat(FormatAZW3, MailFormats)
+	if err == nil || !strings.Contains(err.Error(), "Send-to-Kindle") {
+		t.Errorf("CheckFormat(azw3) = %v, want a Send-to-Kindle error", err)
+	}
+	if err := CheckFormat(FormatMarkdown, BookFormats); err == nil {
+		t.Error("CheckFormat(md) accepted a book format")
+	}
+	if err := CheckFormat(FormatAZW3, Formats); err != nil {
+		t.Errorf("CheckFormat(azw3) for download: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package epubgen
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	contentsFile = "contents.xhtml"
+	failedFile   = "failed.xhtml"
+)
+
+// contentsPage holds what the contents template can show
+type contentsPage struct {
+	Title      string
+	Entries    []contentsEntry
+	Failed     int
+	FailedHref string
+}
+
+type contentsEntry struct {
+	Title    string
+	SiteName string
+	Byline   string
+	Words    int
+	Href     string
+}
+
+// failedPage is one url the book is missing
+type failedPage struct {
+	URL    string
+	Reason string
+}
+
+// chapterFile names the epub file of the i-th chapter, so the contents can link to chapters before they are added
+func chapterFile(i int) string {
+	return fmt.Sprintf("chapter%03d.xhtml", i+1)
+}
+
+// renderContents returns the XHTML body of the front page listing every chapter
+func (l *layout) renderContents(title string, chapters []chapter, failed []fetched) (string, error) {
+	page := contentsPage{Title: title, Failed: len(failed), FailedHref: failedFile}
+	for i, c := range chapters {
+		entry := contentsEntry{
+			Title:    c.article.Title,
+			SiteName: strings.TrimSpace(c.article.SiteName),
+			Byline:   strings.TrimSpace(c.article.Byline),
+			Words:    len(strings.Fields(c.article.TextContent)),
+			Href:     chapterFile(i),
+		}
+		if strings.EqualFold(entry.Byline, entry.SiteName) {
+			entry.Byline = ""
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+
+	var buf bytes.Buffer
+	if err := l.contents.Execute(&buf, page); err != nil {
+		return "", fmt.Errorf("rendering contents: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// renderFailed returns the XHTML body of the appendix listing the pages that could not be fetched
+func (l *layout) renderFailed(failed []fetched) (string, error) {
+	pages := make([]failedPage, 0, len(failed))
+	for _, page := range failed {
+		pages = append(pages, failedPage{URL: page.url, Reason: page.err.Error()})
+	}
+
+	var buf bytes.Buffer
+	if err := l.failed.Execute(&buf, pages); err != nil {
+		return "", fmt.Errorf("rendering failed pages: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,123 @@
+package epubgen
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+)
+
+func TestDigestFrontMatter(t *testing.T) {
+	out := newEpubOutput("Digest", nil)
+	maker := &bookmaker{out: out, format: FormatEPUB, layout: loadLayout("")}
+	chapters := []chapter{
+		{url: "https://example.com/a", article: readability.Article{Title: "First", SiteName: "Example", Byline: "Ann", TextContent: "one two three", Content: "<p>a</p>"}},
+		{url: "https://example.org/b", article: readability.Article{Title: "Second", TextContent: "four five", Content: "<p>b</p>"}},
+	}
+	failed := []fetched{{url: "https://example.net/missing?a=1&b=2", err: errors.New("failed to fetch the page: 404 Not Found")}}
+
+	if err := maker.addContent("Digest", chapters, failed); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "digest.epub")
+	if err := out.book.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	files := readZip(t, path)
+
+	contents := files["EPUB/xhtml/"+contentsFile]
+	for _, want := range []string{
+		`href="chapter001.xhtml">First</a>`, "Example · Ann · 3 words",
+		`href="chapter002.xhtml">Second</a>`, "2 words",
+		`href="failed.xhtml">1 could not be fetched</a>`,
+	} {
+		if !strings.Contains(contents, want) {
+			t.Errorf("contents page is missing %q:\n%s", want, contents)
+		}
+	}
+	if _, ok := files["EPUB/xhtml/chapter002.xhtml"]; !ok {
+		t.Error("chapter file the contents links to is missing")
+	}
+	if appendix := files["EPUB/xhtml/"+failedFile]; !strings.Contains(appendix, "https://example.net/missing?a=1&amp;b=2") ||
+		!strings.Contains(appendix, "404 Not Found") {
+		t.Errorf("appendix does not list the failed page:\n%s", appendix)
+	}
+}
+
+func TestSingleArticleHasNoFrontMatter(t *testing.T) {
+	out := newEpubOutput("Single", nil)
+	maker := &bookmaker{out: out, format: FormatEPUB, layout: loadLayout("")}
+	if err := maker.addContent("Single", []chapter{{article: readability.Article{Title: "Only"}}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "single.epub")
+	if err := out.book.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readZip(t, path)["EPUB/xhtml/"+contentsFile]; ok {
+		t.Error("single article book got a contents page")
+	}
+}
+
+func readZip(t *testing.T, path string) map[string]string {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	return files
+}
+
+func TestDigestLeavesOutChaptersThatFailToRender(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := `<h1>{{.Title}}</h1>{{if eq .Title "Broken"}}{{call .Title}}{{end}}{{.Content}}`
+	if err := os.WriteFile(filepath.Join(dir, chapterTemplateFile), []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := newEpubOutput("Digest", nil)
+	maker := &bookmaker{out: out, format: FormatEPUB, layout: loadLayout(dir)}
+	chapters := []chapter{
+		{url: "https://example.com/a", article: readability.Article{Title: "First", Content: "<p>a</p>"}},
+		{url: "https://example.com/broken", article: readability.Article{Title: "Broken", Content: "<p>b</p>"}},
+		{url: "https://example.com/c", article: readability.Article{Title: "Third", Content: "<p>c</p>"}},
+	}
+
+	chapters, failed := maker.renderable(chapters, nil)
+	if len(chapters) != 2 || len(failed) != 1 || failed[0].url != "https://example.com/broken" {
+		t.Fatalf("broken chapter was not set aside, kept %d, failed %v", len(chapters), failed)
+	}
+	if err := maker.addContent("Digest", chapters, failed); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "digest.epub")
+	if err := out.book.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	files := readZip(t, path)
+
+	contents := files["EPUB/xhtml/"+contentsFile]
+	if strings.Contains(contents, "Broken") || !strings.Contains(contents, `href="chapter002.xhtml">Third</a>`) {
+		t.Errorf("contents page should only list the chapters in the book:\n%s", contents)
+	}
+	if _, ok := files["EPUB/xhtml/chapter003.xhtml"]; ok {
+		t.Error("book has a chapter for the broken article")
+	}
+	if appendix := files["EPUB/xhtml/"+failedFile]; !strings.Contains(appendix, "https://example.com/broken") {
+		t.Errorf("appendix does not list the broken chapter:\n%s", appendix)
+	}
+}
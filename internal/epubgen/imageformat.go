@@ -0,0 +1,185 @@
+package epubgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+
+	// Decoders for formats that are converted to JPEG, AVIF is decoded in builds with the avif tag
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// errAVIF reports an AVIF image the build can't decode. Its decoder runs on a WASM runtime, so it is only
+// compiled in with the avif build tag, and without it AVIF images are dropped from the book.
+var errAVIF = errors.New("AVIF images need a build with the avif tag, the image is left out")
+
+const (
+	jpegQuality = 85
+
+	// SVGs are rasterized with their longest side at most this many pixels
+	maxSVGSide = 1200
+	// SVGs without a usable size are drawn at this width
+	defaultSVGWidth = 800
+)
+
+// embeddedImage is an image re-encoded into a format e-readers display
+type embeddedImage struct {
+	data []byte
+	mime string
+}
+
+// ext returns the file extension for the image type
+func (i embeddedImage) ext() string {
+	switch i.mime {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	default:
+		return ".png"
+	}
+}
+
+// budgetLevels are the steps taken, in order, to shrink the images of a book that is over its byte budget
+var budgetLevels = []struct {
+	scale   float64
+	quality int
+}{
+	{1, 70}, {1, 55}, {0.75, 50}, {0.5, 45}, {0.35, 40},
+}
+
+// prepareImage sniffs the image type from its content, whatever the url or server claim, fits it to the device
+// profile and re-encodes it. Line art (PNG, SVG, GIF) and dithered images end up as PNG, photos as JPEG.
+// GIFs keep only their first frame and stay GIFs when the profile leaves them untouched.
+func prepareImage(data []byte, profile imaging.Profile) (embeddedImage, error) {
+	img, format, err := decodeImage(data)
+	if err != nil {
+		return embeddedImage{}, err
+	}
+
+	applied := profile.Apply(img)
+	switch {
+	case format == "gif" && applied == img:
+		var buf bytes.Buffer
+		if err := gif.Encode(&buf, img, nil); err != nil {
+			return embeddedImage{}, err
+		}
+		return embeddedImage{data: buf.Bytes(), mime: "image/gif"}, nil
+	case format == "png" || format == "svg" || format == "gif" || profile.Dither:
+		return encodePNG(applied)
+	}
+	return encodeJPEG(applied, jpegQuality)
+}
+
+// decodeImage returns the image and its format, SVGs are rasterized
+func decodeImage(data []byte) (image.Image, string, error) {
+	detected := mimetype.Detect(data)
+	if detected.Is("image/svg+xml") {
+		img, err := rasterizeSVG(data)
+		return img, "svg", err
+	}
+
+	// For GIFs this reads only the first frame, animations flicker badly on e-ink
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		if detected.Is("image/avif") {
+			return nil, "", errAVIF
+		}
+		return nil, "", fmt.Errorf("unsupported image type %s: %w", detected.String(), err)
+	}
+	return img, format, nil
+}
+
+// degradeImage re-encodes a prepared image as a smaller, lower quality JPEG
+func degradeImage(prepared embeddedImage, scale float64, quality int) (embeddedImage, error) {
+	img, _, err := image.Decode(bytes.NewReader(prepared.data))
+	if err != nil {
+		return embeddedImage{}, err
+	}
+	if scale < 1 {
+		img = imaging.Scale(img, scale)
+	}
+	return encodeJPEG(img, quality)
+}
+
+func encodePNG(img image.Image) (embeddedImage, error) {
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.DefaultCompression}
+	if err := encoder.Encode(&buf, to8Bit(img)); err != nil {
+		return embeddedImage{}, err
+	}
+	return embeddedImage{data: buf.Bytes(), mime: "image/png"}, nil
+}
+
+// to8Bit converts 16-bit images to 8 bits per channel. Screens show no difference, the files are half the size
+// and PDF writers reject 16-bit PNGs.
+func to8Bit(img image.Image) image.Image {
+	var converted draw.Image
+	switch img.(type) {
+	case *image.Gray16:
+		converted = image.NewGray(img.Bounds())
+	case *image.NRGBA64, *image.RGBA64:
+		converted = image.NewNRGBA(img.Bounds())
+	default:
+		return img
+	}
+	draw.Draw(converted, img.Bounds(), img, img.Bounds().Min, draw.Src)
+	return converted
+}
+
+func encodeJPEG(img image.Image, quality int) (embeddedImage, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality}); err != nil {
+		return embeddedImage{}, err
+	}
+	return embeddedImage{data: buf.Bytes(), mime: "image/jpeg"}, nil
+}
+
+// flatten draws images with transparency onto white, JPEG would otherwise turn transparent areas black
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	bounds := img.Bounds()
+	flat := image.NewRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+	return flat
+}
+
+// rasterizeSVG draws an SVG at its own size, scaled down to fit maxSVGSide
+func rasterizeSVG(data []byte) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid svg: %w", err)
+	}
+
+	w, h := icon.ViewBox.W, icon.ViewBox.H
+	if w <= 0 || h <= 0 {
+		w, h = defaultSVGWidth, defaultSVGWidth*3/4
+	}
+	if longest := math.Max(w, h); longest > maxSVGSide {
+		w, h = w*maxSVGSide/longest, h*maxSVGSide/longest
+	}
+	width, height := int(math.Ceil(w)), int(math.Ceil(h))
+
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
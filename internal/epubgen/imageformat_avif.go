@@ -0,0 +1,6 @@
+//go:build avif
+
+package epubgen
+
+// AVIF images are converted to JPEG like WebP, see errAVIF for builds without the tag
+import _ "github.com/gen2brain/avif"
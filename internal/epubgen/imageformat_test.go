@@ -0,0 +1,151 @@
+package epubgen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+)
+
+// 1x1 lossless WebP
+const tinyWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func TestPrepareImageFormats(t *testing.T) {
+	webp, _ := base64.StdEncoding.DecodeString(tinyWebP)
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 2400 600"><rect width="2400" height="600" fill="#000"/></svg>`)
+
+	tests := []struct {
+		name string
+		data []byte
+		mime string
+		ext  string
+	}{
+		{"png", pngBytes(t, 4), "image/png", ".png"},
+		{"jpeg", jpg.Bytes(), "image/jpeg", ".jpg"},
+		{"webp", webp, "image/jpeg", ".jpg"},
+		{"svg", svg, "image/png", ".png"},
+	}
+	for _, tt := range tests {
+		prepared, err := prepareImage(tt.data, imaging.Profile{})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if prepared.mime != tt.mime || prepared.ext() != tt.ext {
+			t.Errorf("%s: got %s %s, want %s %s", tt.name, prepared.mime, prepared.ext(), tt.mime, tt.ext)
+		}
+	}
+
+	if _, err := prepareImage([]byte("<html>not an image</html>"), imaging.Profile{}); err == nil {
+		t.Error("expected an error for html served as an image")
+	}
+
+	// AVIF has no decoder, the image is left out with an error naming the format
+	avif := append([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf"), make([]byte, 64)...)
+	if _, err := prepareImage(avif, imaging.Profile{}); err == nil || !strings.Contains(err.Error(), "AVIF") {
+		t.Errorf("AVIF image: %v, want an AVIF error", err)
+	}
+}
+
+func TestPrepareImageSVGSize(t *testing.T) {
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 2400 600"><rect width="2400" height="600" fill="#000"/></svg>`)
+	prepared, err := prepareImage(svg, imaging.Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(prepared.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != maxSVGSide || size.Y != 300 {
+		t.Errorf("rasterized at %v, want %dx300", size, maxSVGSide)
+	}
+}
+
+func TestPrepareImageGIFFirstFrame(t *testing.T) {
+	palette := color.Palette{color.White, color.Black}
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frame.SetColorIndex(i, i, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	prepared, err := prepareImage(buf.Bytes(), imaging.Profile{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prepared.mime != "image/gif" {
+		t.Fatalf("got %s, want image/gif", prepared.mime)
+	}
+	decoded, err := gif.DecodeAll(bytes.NewReader(prepared.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Image) != 1 {
+		t.Errorf("got %d frames, want only the first", len(decoded.Image))
+	}
+}
+
+func TestPrepareImageProfile(t *testing.T) {
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, noisy(1608, 1000), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	prepared, err := prepareImage(photo.Bytes(), imaging.Profiles["kindle"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(prepared.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("got %T, want a grayscale jpeg", img)
+	}
+	if size := img.Bounds().Size(); size.X != 1072 || size.Y != 666 {
+		t.Errorf("resized to %v, want 1072x666", size)
+	}
+	if len(prepared.data) >= photo.Len()/2 {
+		t.Errorf("prepared image is %d bytes, original %d", len(prepared.data), photo.Len())
+	}
+
+	dithered, err := prepareImage(photo.Bytes(), imaging.Profile{MaxWidth: 200, Dither: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dithered.mime != "image/png" {
+		t.Errorf("dithered image stored as %s, want png", dithered.mime)
+	}
+}
+
+func noisy(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	return img
+}
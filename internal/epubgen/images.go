@@ -0,0 +1,314 @@
+package epubgen
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+	"github.com/ryan-gang/kindle-send-daemon/internal/util"
+)
+
+const imageTimeout = 20 * time.Second
+
+// imagePipeline downloads the images of every article in a book through a bounded pool of workers.
+// Each image url is downloaded and added to the book once, however many articles use it.
+type imagePipeline struct {
+	store    imageStore
+	client   *http.Client
+	workers  int
+	maxBytes int64
+	profile  imaging.Profile
+
+	// mu guards prepared and dropped, which the download workers fill concurrently
+	mu       sync.Mutex
+	prepared map[string]embeddedImage
+	// dropped holds images removed from the book rather than left pointing at the web, see errAVIF
+	dropped map[string]bool
+
+	// refs maps image urls to their path in the book, written once all downloads are done
+	refs map[string]string
+
+	// localDirs maps the file urls of images in local documents to the folder of their document, set before
+	// the downloads start
+	localDirs map[string]string
+}
+
+func newImagePipeline(store imageStore, workers int, maxBytes int64, profile imaging.Profile) *imagePipeline {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &imagePipeline{
+		store:     store,
+		client:    &http.Client{Timeout: imageTimeout},
+		workers:   workers,
+		maxBytes:  maxBytes,
+		profile:   profile,
+		prepared:  make(map[string]embeddedImage),
+		dropped:   make(map[string]bool),
+		refs:      make(map[string]string),
+		localDirs: make(map[string]string),
+	}
+}
+
+// embed downloads the images of all chapters and points their img tags to the embedded copies.
+// Images that fail keep their remote src, except those in a format the reader can't show, which are removed.
+func (p *imagePipeline) embed(ctx context.Context, chapters []chapter) {
+	docs := make([]*goquery.Document, len(chapters))
+	var sources []string
+	seen := make(map[string]bool)
+	for i := range chapters {
+		docs[i] = goquery.NewDocumentFromNode(chapters[i].article.Node)
+		docs[i].Find("img").Each(func(_ int, img *goquery.Selection) {
+			src, ok := img.Attr("src")
+			if !ok || src == "" {
+				return
+			}
+			if chapters[i].localDir != "" {
+				p.localDirs[src] = chapters[i].localDir
+			}
+			if !seen[src] {
+				seen[src] = true
+				sources = append(sources, src)
+			}
+		})
+	}
+
+	if len(sources) > 0 {
+		util.CyanBold.Printf("Downloading %d images\n", len(sources))
+		p.downloadAll(ctx, sources)
+		p.fitBudget()
+		p.storeAll(sources)
+	}
+
+	for i, doc := range docs {
+		doc.Find("img").Each(p.changeRef)
+		content, err := doc.Html()
+		if err != nil {
+			util.Red.Printf("Error converting modified %s to HTML, it will be transferred without images : %s \n", chapters[i].article.Title, err)
+			continue
+		}
+		chapters[i].article.Content = content
+	}
+}
+
+func (p *imagePipeline) downloadAll(ctx context.Context, sources []string) {
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < min(p.workers, len(sources)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range jobs {
+				if err := p.add(ctx, src); err != nil {
+					util.Red.Printf("Couldn't add image %s : %s\n", src, err)
+				}
+			}
+		}()
+	}
+
+	for _, src := range sources {
+		jobs <- src
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// add downloads one image and prepares it for the device
+func (p *imagePipeline) add(ctx context.Context, src string) error {
+	imgData, err := p.download(ctx, src, p.localDirs[src])
+	if err != nil {
+		return err
+	}
+
+	// Images that cannot be decoded are left out rather than embedded in a format the reader may not show
+	prepared, err := prepareImage(imgData, p.profile)
+	if errors.Is(err, errAVIF) {
+		p.mu.Lock()
+		p.dropped[src] = true
+		p.mu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	originalSize := len(imgData)
+	compressedSize := len(prepared.data)
+	reduction := float64(originalSize-compressedSize) / float64(originalSize) * 100
+	util.Green.Printf("Image %s compressed: %d KB → %d KB (%.1f%% reduction)\n",
+		filepath.Base(src), originalSize/1024, compressedSize/1024, reduction)
+
+	p.mu.Lock()
+	p.prepared[src] = prepared
+	p.mu.Unlock()
+	return nil
+}
+
+// fitBudget shrinks every image one step at a time until their total size fits the profile's budget
+func (p *imagePipeline) fitBudget() {
+	budget := p.profile.BudgetBytes
+	if budget <= 0 || p.totalSize() <= budget {
+		return
+	}
+
+	// Each step starts again from the prepared images, so quality is not lost twice
+	originals := make(map[string]embeddedImage, len(p.prepared))
+	for src, prepared := range p.prepared {
+		originals[src] = prepared
+	}
+
+	for _, level := range budgetLevels {
+		for src, original := range originals {
+			degraded, err := degradeImage(original, level.scale, level.quality)
+			if err == nil && len(degraded.data) < len(original.data) {
+				p.prepared[src] = degraded
+			}
+		}
+		total := p.totalSize()
+		util.Cyan.Printf("Images re-encoded at quality %d and %.0f%% size to fit the %d KB budget: %d KB\n",
+			level.quality, level.scale*100, budget/1024, total/1024)
+		if total <= budget {
+			return
+		}
+	}
+	util.Red.Printf("Images still take %d KB, over the %d KB budget\n", p.totalSize()/1024, budget/1024)
+}
+
+func (p *imagePipeline) totalSize() int64 {
+	var total int64
+	for _, prepared := range p.prepared {
+		total += int64(len(prepared.data))
+	}
+	return total
+}
+
+// storeAll adds the prepared images to the book, in the order they appear in it
+func (p *imagePipeline) storeAll(sources []string) {
+	for _, src := range sources {
+		prepared, ok := p.prepared[src]
+		if !ok {
+			continue
+		}
+
+		// pass unique and safe image names here, then it will not crash on windows
+		imgRef, err := p.store.addImage("img"+util.GetHash(src)+prepared.ext(), prepared)
+		if err != nil {
+			util.Red.Printf("Couldn't add image %s : %s\n", src, err)
+			continue
+		}
+		p.refs[src] = imgRef
+	}
+}
+
+// dataURL inlines a file for go-epub, which reads media sources only when the book is written.
+// A data url keeps the bytes in memory until then.
+func dataURL(mime string, data []byte) string {
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// download fetches an image, refusing anything larger than maxBytes. file urls are only read for images of the
+// local document in localDir.
+func (p *imagePipeline) download(ctx context.Context, src, localDir string) ([]byte, error) {
+	if parsed, err := url.Parse(src); err == nil && parsed.Scheme == "file" {
+		return p.readLocalFile(filepath.FromSlash(parsed.Path), localDir)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if p.maxBytes > 0 && resp.ContentLength > p.maxBytes {
+		return nil, fmt.Errorf("image is %d KB, larger than the %d KB limit", resp.ContentLength/1024, p.maxBytes/1024)
+	}
+	return readLimited(resp.Body, p.maxBytes)
+}
+
+// maxLocalImageBytes caps images read from disk when no size limit is configured
+const maxLocalImageBytes = 64 << 20
+
+// readLocalFile reads an image of a local document. Pages from the web can't read the disk, and a document only
+// reads regular files inside its own folder, so a book can't pick up unrelated private files.
+func (p *imagePipeline) readLocalFile(path, localDir string) ([]byte, error) {
+	if localDir == "" {
+		return nil, errors.New("images on disk are only read for local documents")
+	}
+	root, err := filepath.EvalSymlinks(localDir)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside the folder of the document", path)
+	}
+
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	limit := p.maxBytes
+	if limit <= 0 {
+		limit = maxLocalImageBytes
+	}
+	return readLimited(file, limit)
+}
+
+// readLimited reads r whole, failing once it goes past maxBytes. maxBytes of 0 or less reads without a limit.
+func readLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes > 0 {
+		r = io.LimitReader(r, maxBytes+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("image is larger than the %d KB limit", maxBytes/1024)
+	}
+	return data, nil
+}
+
+// changeRef points a remote image link to the downloaded image
+func (p *imagePipeline) changeRef(_ int, img *goquery.Selection) {
+	img.RemoveAttr("loading")
+	img.RemoveAttr("srcset")
+	imgSrc, exists := img.Attr("src")
+	if !exists {
+		return
+	}
+
+	if ref, ok := p.refs[imgSrc]; ok {
+		img.SetAttr("src", ref)
+	} else if p.dropped[imgSrc] {
+		img.Remove()
+	}
+}
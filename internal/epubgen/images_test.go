@@ -0,0 +1,171 @@
+package epubgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-shiori/go-readability"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+)
+
+func pngBytes(t *testing.T, size int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x * y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func parseChapter(t *testing.T, base, body string) chapter {
+	page := `<html><head><title>Test</title></head><body><article>
+		<p>Some article text that is long enough for readability to keep the paragraph around.</p>` + body + `
+		<p>More article text so the images sit inside the main content of the page.</p></article></body></html>`
+	pageURL, _ := url.Parse(base)
+	article, err := readability.FromReader(strings.NewReader(page), pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chapter{url: base, article: article}
+}
+
+func TestImagePipelineSharesDownloads(t *testing.T) {
+	small, large := pngBytes(t, 8), pngBytes(t, 200)
+
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/shared.png", "/a.png", "/b.png":
+			w.Write(small)
+		case "/large.png":
+			w.Write(large)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	chapters := []chapter{
+		parseChapter(t, server.URL, fmt.Sprintf(`<img src="%[1]s/shared.png"><img src="%[1]s/a.png"><img src="%[1]s/large.png">`, server.URL)),
+		parseChapter(t, server.URL, fmt.Sprintf(`<img src="%[1]s/shared.png"><img src="%[1]s/b.png"><img src="%[1]s/missing.png">`, server.URL)),
+	}
+
+	out := newEpubOutput("Images", nil)
+	book := out.book
+	pipeline := newImagePipeline(out, 3, int64(len(small)+100), imaging.Profile{})
+	pipeline.embed(context.Background(), chapters)
+
+	for path, count := range hits {
+		if count != 1 {
+			t.Errorf("%s was downloaded %d times", path, count)
+		}
+	}
+
+	for _, name := range []string{"shared.png", "a.png"} {
+		if strings.Contains(chapters[0].article.Content, server.URL+"/"+name) {
+			t.Errorf("%s was not replaced with the embedded copy", name)
+		}
+	}
+	if !strings.Contains(chapters[0].article.Content, server.URL+"/large.png") {
+		t.Error("image over the size limit should keep its remote src")
+	}
+	if !strings.Contains(chapters[1].article.Content, server.URL+"/missing.png") {
+		t.Error("failed image should keep its remote src")
+	}
+	if !strings.Contains(chapters[1].article.Content, "../images/") {
+		t.Error("second article does not reference embedded images")
+	}
+
+	for _, c := range chapters {
+		if _, err := book.AddSection(c.article.Content, c.article.Title, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := book.Write(filepath.Join(t.TempDir(), "images.epub")); err != nil {
+		t.Fatalf("writing epub with embedded images: %v", err)
+	}
+}
+
+func TestImagePipelineDropsAVIF(t *testing.T) {
+	// Only the header, which is what the format is detected from
+	avif := append([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf"), make([]byte, 64)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(avif)
+	}))
+	defer server.Close()
+
+	chapters := []chapter{parseChapter(t, server.URL, `<img src="`+server.URL+`/photo.avif">`)}
+	newImagePipeline(newEpubOutput("AVIF", nil), 1, 0, imaging.Profile{}).embed(context.Background(), chapters)
+	if strings.Contains(chapters[0].article.Content, "photo.avif") {
+		t.Error("undecodable AVIF image was left pointing at the web")
+	}
+}
+
+func TestImagePipelineCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("%s was requested after cancellation", r.URL.Path)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	chapters := []chapter{parseChapter(t, server.URL, `<img src="`+server.URL+`/a.png">`)}
+	newImagePipeline(newEpubOutput("Cancelled", nil), 2, 0, imaging.Profile{}).embed(ctx, chapters)
+	if !strings.Contains(chapters[0].article.Content, server.URL+"/a.png") {
+		t.Error("image should keep its remote src")
+	}
+}
+
+func TestImagePipelineBudget(t *testing.T) {
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, noisy(400, 300), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(photo.Bytes())
+	}))
+	defer server.Close()
+
+	var body strings.Builder
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(&body, `<img src="%s/%d.jpg">`, server.URL, i)
+	}
+	chapters := []chapter{parseChapter(t, server.URL, body.String())}
+
+	unlimited := newImagePipeline(newEpubOutput("Unlimited", nil), 2, 0, imaging.Profile{Grayscale: true})
+	unlimited.embed(context.Background(), []chapter{parseChapter(t, server.URL, body.String())})
+	full := unlimited.totalSize()
+
+	budget := full / 3
+	pipeline := newImagePipeline(newEpubOutput("Budget", nil), 2, 0, imaging.Profile{Grayscale: true, BudgetBytes: budget})
+	pipeline.embed(context.Background(), chapters)
+
+	if len(pipeline.refs) != 4 {
+		t.Fatalf("%d images embedded, want 4", len(pipeline.refs))
+	}
+	if total := pipeline.totalSize(); total > budget {
+		t.Errorf("images take %d bytes, over the %d byte budget (%d without one)", total, budget, full)
+	}
+}
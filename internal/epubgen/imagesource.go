@@ -0,0 +1,211 @@
+package epubgen
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// lazySrcAttrs hold the real image url on pages that load images with javascript, most specific first
+var lazySrcAttrs = []string{
+	"data-src", "data-original", "data-lazy-src", "data-hi-res-src", "data-full-src", "data-url", "data-lazyload",
+}
+
+// lazySrcsetAttrs are the srcset counterparts of lazySrcAttrs
+var lazySrcsetAttrs = []string{"data-srcset", "data-lazy-srcset"}
+
+// srcsetCandidate is one entry of a srcset attribute
+type srcsetCandidate struct {
+	url     string
+	width   int     // from a w descriptor, 0 when absent
+	density float64 // from an x descriptor, 1 when absent
+}
+
+// resolveImages rewrites every image of a page so its src is the absolute url of the best image to download.
+// noscript fallbacks replace their lazy-loading placeholders, <picture> elements are reduced to their <img>,
+// and srcset candidates are weighed against targetWidth, the width of the device screen (0 picks the largest).
+func resolveImages(doc *goquery.Document, base *url.URL, targetWidth int) {
+	unwrapNoscriptImages(doc)
+
+	doc.Find("picture").Each(func(_ int, picture *goquery.Selection) {
+		img := picture.Find("img").First()
+		if img.Length() == 0 {
+			picture.Remove()
+			return
+		}
+		var candidates []srcsetCandidate
+		picture.Find("source").Each(func(_ int, source *goquery.Selection) {
+			srcset, _ := source.Attr("srcset")
+			if srcset == "" {
+				srcset = firstAttr(source, lazySrcsetAttrs)
+			}
+			candidates = append(candidates, parseSrcset(srcset)...)
+		})
+		if best := pickCandidate(candidates, targetWidth); best != "" && !hasSrcset(img) {
+			img.SetAttr("srcset", best)
+		}
+		picture.ReplaceWithSelection(img)
+	})
+
+	doc.Find("img").Each(func(_ int, img *goquery.Selection) {
+		src := bestImageSrc(img, targetWidth)
+		if src == "" {
+			return
+		}
+		if resolved, err := base.Parse(src); err == nil {
+			src = resolved.String()
+		}
+		img.SetAttr("src", src)
+		for _, attr := range append(append([]string{"srcset", "sizes", "loading"}, lazySrcAttrs...), lazySrcsetAttrs...) {
+			img.RemoveAttr(attr)
+		}
+	})
+}
+
+// unwrapNoscriptImages replaces noscript blocks holding images with their content, dropping the placeholder
+// image that usually sits right before them
+func unwrapNoscriptImages(doc *goquery.Document) {
+	doc.Find("noscript").Each(func(_ int, noscript *goquery.Selection) {
+		fallback, err := goquery.NewDocumentFromReader(strings.NewReader(noscript.Text()))
+		if err != nil {
+			return
+		}
+		images := fallback.Find("img")
+		if images.Length() == 0 {
+			return
+		}
+
+		if prev := noscript.Prev(); prev.Is("img") && isPlaceholder(prev) {
+			prev.Remove()
+		}
+		noscript.ReplaceWithSelection(fallback.Find("body").Contents())
+	})
+}
+
+// isPlaceholder reports whether an img only stands in for a lazily loaded image
+func isPlaceholder(img *goquery.Selection) bool {
+	src, _ := img.Attr("src")
+	return src == "" || strings.HasPrefix(src, "data:") || firstAttr(img, lazySrcAttrs) != "" || firstAttr(img, lazySrcsetAttrs) != ""
+}
+
+// bestImageSrc picks the image url from srcset, lazy-load attributes and src, in that order
+func bestImageSrc(img *goquery.Selection, targetWidth int) string {
+	srcset, _ := img.Attr("srcset")
+	if lazy := firstAttr(img, lazySrcsetAttrs); lazy != "" {
+		srcset = lazy
+	}
+	if best := pickCandidate(parseSrcset(srcset), targetWidth); best != "" {
+		return best
+	}
+	if lazy := firstAttr(img, lazySrcAttrs); lazy != "" {
+		return lazy
+	}
+	src, _ := img.Attr("src")
+	return strings.TrimSpace(src)
+}
+
+func firstAttr(s *goquery.Selection, attrs []string) string {
+	for _, attr := range attrs {
+		if value, ok := s.Attr(attr); ok && strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func hasSrcset(img *goquery.Selection) bool {
+	srcset, _ := img.Attr("srcset")
+	return strings.TrimSpace(srcset) != ""
+}
+
+// parseSrcset splits a srcset attribute into its candidates. Urls may contain commas, so a candidate
+// only ends at a comma that follows the url and its descriptors.
+func parseSrcset(srcset string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+	rest := srcset
+	for {
+		rest = strings.TrimLeftFunc(rest, func(r rune) bool { return unicode.IsSpace(r) || r == ',' })
+		if rest == "" {
+			return candidates
+		}
+
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		token := rest[:end]
+		candidate := srcsetCandidate{url: strings.TrimRight(token, ","), density: 1}
+		rest = rest[end:]
+
+		// A url ending in a comma has no descriptors
+		descriptor := ""
+		if !strings.HasSuffix(token, ",") {
+			descriptor = rest
+			if comma := strings.Index(rest, ","); comma >= 0 {
+				descriptor, rest = rest[:comma], rest[comma+1:]
+			} else {
+				rest = ""
+			}
+		}
+		for _, field := range strings.Fields(descriptor) {
+			switch {
+			case strings.HasSuffix(field, "w"):
+				candidate.width, _ = strconv.Atoi(strings.TrimSuffix(field, "w"))
+			case strings.HasSuffix(field, "x"):
+				if density, err := strconv.ParseFloat(strings.TrimSuffix(field, "x"), 64); err == nil {
+					candidate.density = density
+				}
+			}
+		}
+		if candidate.url != "" && !strings.HasPrefix(candidate.url, "data:") {
+			candidates = append(candidates, candidate)
+		}
+	}
+}
+
+// fallbackImageWidth is the screen width candidates are picked for when the profile leaves the width unbounded,
+// so an unbounded profile doesn't pull in the largest image a page offers
+const fallbackImageWidth = 1072
+
+// pickCandidate returns the smallest candidate at least targetWidth wide, or the largest one when none is.
+// Candidates with only a density descriptor are compared by density.
+func pickCandidate(candidates []srcsetCandidate, targetWidth int) string {
+	if targetWidth <= 0 {
+		targetWidth = fallbackImageWidth
+	}
+	var best *srcsetCandidate
+	for i := range candidates {
+		c := &candidates[i]
+		if best == nil {
+			best = c
+			continue
+		}
+		if c.width == 0 || best.width == 0 {
+			if c.width > best.width || (c.width == best.width && c.density > best.density) {
+				best = c
+			}
+			continue
+		}
+
+		cFits, bestFits := c.width >= targetWidth, best.width >= targetWidth
+		switch {
+		case cFits && !bestFits:
+			best = c
+		case cFits && bestFits:
+			if c.width < best.width {
+				best = c
+			}
+		case !cFits && !bestFits:
+			if c.width > best.width {
+				best = c
+			}
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.url
+}
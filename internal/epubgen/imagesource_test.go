@@ -0,0 +1,93 @@
+package epubgen
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestParseSrcset(t *testing.T) {
+	got := parseSrcset(`/img,crop=1.jpg 480w, /img-2x.jpg 2x,/small.jpg, data:image/gif;base64,R0lGOD 1w`)
+	want := []srcsetCandidate{
+		{url: "/img,crop=1.jpg", width: 480, density: 1},
+		{url: "/img-2x.jpg", density: 2},
+		{url: "/small.jpg", density: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("candidate %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPickCandidate(t *testing.T) {
+	widths := parseSrcset("a.jpg 320w, b.jpg 800w, c.jpg 1200w, d.jpg 2400w")
+	tests := []struct {
+		target int
+		want   string
+	}{
+		{1072, "c.jpg"},
+		{800, "b.jpg"},
+		{3000, "d.jpg"},
+		{0, "c.jpg"},
+	}
+	for _, tt := range tests {
+		if got := pickCandidate(widths, tt.target); got != tt.want {
+			t.Errorf("target %d: got %s, want %s", tt.target, got, tt.want)
+		}
+	}
+
+	if got := pickCandidate(parseSrcset("a.jpg, b.jpg 3x, c.jpg 2x"), 1072); got != "b.jpg" {
+		t.Errorf("density candidates: got %s, want b.jpg", got)
+	}
+}
+
+func TestResolveImages(t *testing.T) {
+	page := `<html><body>
+		<img id="plain" src="plain.png">
+		<img id="lazy" src="data:image/gif;base64,R0lGOD" data-src="/lazy.jpg">
+		<img id="sized" src="small.jpg" srcset="small.jpg 400w, medium.jpg 1100w, large.jpg 2000w" sizes="100vw">
+		<img class="placeholder" src="data:image/gif;base64,R0lGOD" data-lazy-src="/wrong.jpg">
+		<noscript><img id="fallback" src="//cdn.example.com/real.jpg"></noscript>
+		<picture>
+			<source type="image/webp" srcset="pic-800.webp 800w, pic-1600.webp 1600w">
+			<img id="picture" src="pic-small.jpg">
+		</picture>
+	</body></html>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse("https://example.com/posts/article.html")
+	resolveImages(doc, base, 1072)
+
+	want := map[string]string{
+		"plain":    "https://example.com/posts/plain.png",
+		"lazy":     "https://example.com/lazy.jpg",
+		"sized":    "https://example.com/posts/medium.jpg",
+		"fallback": "https://cdn.example.com/real.jpg",
+		"picture":  "https://example.com/posts/pic-1600.webp",
+	}
+	for id, src := range want {
+		img := doc.Find("#" + id)
+		if img.Length() != 1 {
+			t.Errorf("#%s is missing", id)
+			continue
+		}
+		if got, _ := img.Attr("src"); got != src {
+			t.Errorf("#%s: got src %s, want %s", id, got, src)
+		}
+		if _, ok := img.Attr("srcset"); ok {
+			t.Errorf("#%s still has a srcset", id)
+		}
+	}
+
+	if doc.Find(".placeholder, noscript, picture").Length() != 0 {
+		t.Error("placeholder, noscript or picture left in the page")
+	}
+}
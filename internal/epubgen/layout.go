@@ -0,0 +1,93 @@
+package epubgen
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/util"
+)
+
+// Files in the config directory that replace the bundled templates and stylesheet
+const (
+	chapterTemplateFile  = "chapter.html"
+	contentsTemplateFile = "contents.html"
+	failedTemplateFile   = "failed.html"
+	documentTemplateFile = "document.html"
+	stylesheetFile       = "style.css"
+)
+
+//go:embed templates
+var bundled embed.FS
+
+// layout renders the pages of a book with the bundled templates and stylesheet, or the ones found in the config directory
+type layout struct {
+	chapter    *template.Template
+	contents   *template.Template
+	failed     *template.Template
+	document   *template.Template
+	stylesheet []byte
+}
+
+// loadLayout reads the template and stylesheet overrides from dir. Missing or broken overrides fall back to
+// the bundled files, an empty dir uses them directly.
+func loadLayout(dir string) *layout {
+	l := &layout{
+		chapter:    loadTemplate(dir, chapterTemplateFile),
+		contents:   loadTemplate(dir, contentsTemplateFile),
+		failed:     loadTemplate(dir, failedTemplateFile),
+		document:   loadTemplate(dir, documentTemplateFile),
+		stylesheet: mustBundled(stylesheetFile),
+	}
+
+	if css, err := readOverride(dir, stylesheetFile); err != nil {
+		util.Red.Printf("Couldn't read stylesheet, using the bundled one : %s\n", err)
+	} else if css != nil {
+		l.stylesheet = css
+	}
+	return l
+}
+
+// loadTemplate parses dir/name, or the bundled template of that name when there is no usable override
+func loadTemplate(dir, name string) *template.Template {
+	bundledTemplate := template.Must(template.New(name).Parse(string(mustBundled(name))))
+
+	text, err := readOverride(dir, name)
+	if err != nil {
+		util.Red.Printf("Couldn't read template %s, using the bundled one : %s\n", name, err)
+		return bundledTemplate
+	}
+	if text == nil {
+		return bundledTemplate
+	}
+	tmpl, err := template.New(name).Parse(string(text))
+	if err != nil {
+		util.Red.Printf("Invalid template %s, using the bundled one : %s\n", filepath.Join(dir, name), err)
+		return bundledTemplate
+	}
+	return tmpl
+}
+
+func mustBundled(name string) []byte {
+	data, err := bundled.ReadFile(path.Join("templates", name))
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// readOverride returns the content of dir/name, or nil when dir is empty or has no such file
+func readOverride(dir, name string) ([]byte, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
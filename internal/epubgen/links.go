@@ -0,0 +1,122 @@
+package epubgen
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ryan-gang/kindle-send-daemon/internal/canonical"
+	"github.com/ryan-gang/kindle-send-daemon/internal/util"
+)
+
+// linkRewriter keeps links between the articles of a book inside the book, and can list the targets of the other
+// links as footnotes since they can't be followed on an offline reader
+type linkRewriter struct {
+	rules canonical.Rules
+	// files maps the canonical url of every chapter to its file in the epub
+	files     map[string]string
+	footnotes bool
+}
+
+// newLinkRewriter points links at chapters, which must be the chapters going into the book in their final order.
+// Links to any other page, including articles that were left out of the book, stay on the web.
+func newLinkRewriter(chapters []chapter, rules canonical.Rules, footnotes bool) *linkRewriter {
+	// Fragments point inside a page, they are matched separately
+	rules.KeepFragment = false
+	r := &linkRewriter{rules: rules, files: make(map[string]string), footnotes: footnotes}
+	for i, c := range chapters {
+		if key := r.key(c.url); key != "" {
+			r.files[key] = chapterFile(i)
+		}
+	}
+	return r
+}
+
+// key is the canonical form of u, or "" for links that are not web pages
+func (r *linkRewriter) key(u string) string {
+	normalized, err := canonical.Normalize(u, r.rules)
+	if err != nil {
+		return ""
+	}
+	return normalized
+}
+
+// rewrite updates the links of every chapter
+func (r *linkRewriter) rewrite(chapters []chapter) {
+	for i := range chapters {
+		if chapters[i].article.Node == nil {
+			continue
+		}
+		base, err := url.Parse(chapters[i].url)
+		if err != nil {
+			continue
+		}
+
+		doc := goquery.NewDocumentFromNode(chapters[i].article.Node)
+		var notes []string
+		numbers := make(map[string]int)
+		doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+			href, _ := a.Attr("href")
+			if strings.HasPrefix(href, "#") {
+				return
+			}
+			target, err := base.Parse(strings.TrimSpace(href))
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+				return
+			}
+
+			if file, ok := r.files[r.key(target.String())]; ok {
+				if target.Fragment != "" {
+					file += "#" + target.Fragment
+				}
+				a.SetAttr("href", file)
+				return
+			}
+
+			if !r.footnotes || strings.TrimSpace(a.Text()) == target.String() {
+				return
+			}
+			n, ok := numbers[target.String()]
+			if !ok {
+				notes = append(notes, target.String())
+				n = len(notes)
+				numbers[target.String()] = n
+			}
+			a.AfterSelection(parseFragment(fmt.Sprintf(`<sup class="footnote-ref"><a href="#footnote-%d">[%d]</a></sup>`, n, n)))
+		})
+
+		if len(notes) > 0 {
+			doc.AppendSelection(parseFragment(footnoteList(notes)))
+		}
+		content, err := doc.Html()
+		if err != nil {
+			util.Red.Printf("Error converting %s to HTML, its links were not rewritten : %s\n", chapters[i].article.Title, err)
+			continue
+		}
+		chapters[i].article.Content = content
+	}
+}
+
+// footnoteList renders the numbered list of link targets closing a chapter
+func footnoteList(notes []string) string {
+	var b strings.Builder
+	b.WriteString(`<div class="footnotes"><h2>Links</h2><ol>`)
+	for i, note := range notes {
+		escaped := html.EscapeString(note)
+		fmt.Fprintf(&b, `<li id="footnote-%d"><a href="%s">%s</a></li>`, i+1, escaped, escaped)
+	}
+	b.WriteString(`</ol></div>`)
+	return b.String()
+}
+
+// parseFragment parses markup on its own. goquery's *Html methods parse in the context of the target element,
+// which fails for the elements readability creates without an atom.
+func parseFragment(markup string) *goquery.Selection {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(markup))
+	if err != nil {
+		return &goquery.Selection{}
+	}
+	return doc.Find("body").Contents()
+}
@@ -0,0 +1,71 @@
+package epubgen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/canonical"
+)
+
+func TestRewriteLinks(t *testing.T) {
+	chapters := []chapter{
+		parseChapter(t, "https://example.com/posts/first", `<p>See <a href="/posts/second?utm_source=x#part-2">the follow-up</a>,
+			<a href="https://other.org/ref">a reference</a>, <a href="https://other.org/ref">it again</a>,
+			<a href="#top">the top</a> and <a href="mailto:me@example.com">mail</a>.</p>`),
+		parseChapter(t, "https://www.example.com/posts/second/", `<p>Back to <a href="http://example.com/posts/first">the first post</a>.</p>`),
+	}
+
+	newLinkRewriter(chapters, canonical.Rules{}, true).rewrite(chapters)
+
+	first, second := chapters[0].article.Content, chapters[1].article.Content
+	for _, want := range []string{
+		`href="chapter002.xhtml#part-2"`,
+		`<a href="https://other.org/ref">a reference</a><sup class="footnote-ref"><a href="#footnote-1">[1]</a></sup>`,
+		`<a href="https://other.org/ref">it again</a><sup class="footnote-ref"><a href="#footnote-1">[1]</a></sup>`,
+		`<li id="footnote-1"><a href="https://other.org/ref">https://other.org/ref</a></li>`,
+		`href="#top"`,
+		`href="mailto:me@example.com"`,
+	} {
+		if !strings.Contains(first, want) {
+			t.Errorf("first chapter is missing %q:\n%s", want, first)
+		}
+	}
+	if strings.Contains(first, "footnote-2") {
+		t.Error("repeated link got a second footnote")
+	}
+	if !strings.Contains(second, `href="chapter001.xhtml"`) || strings.Contains(second, "footnotes") {
+		t.Errorf("second chapter link not rewritten:\n%s", second)
+	}
+}
+
+func TestRewriteLinksWithoutFootnotes(t *testing.T) {
+	chapters := []chapter{parseChapter(t, "https://example.com/a", `<p><a href="https://other.org/">elsewhere</a></p>`)}
+	newLinkRewriter(chapters, canonical.Rules{}, false).rewrite(chapters)
+	if content := chapters[0].article.Content; strings.Contains(content, "footnote") || !strings.Contains(content, `href="https://other.org/"`) {
+		t.Errorf("external link should be left alone:\n%s", content)
+	}
+}
+
+func TestRewriteLinksSkipsChaptersLeftOut(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := `<h1>{{.Title}}</h1>{{if eq .URL "https://example.com/broken"}}{{call .Title}}{{end}}{{.Content}}`
+	if err := os.WriteFile(filepath.Join(dir, chapterTemplateFile), []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	maker := &bookmaker{out: newEpubOutput("Digest", nil), format: FormatEPUB, layout: loadLayout(dir)}
+	chapters := []chapter{
+		parseChapter(t, "https://example.com/a", `<p><a href="/broken">the broken one</a> and <a href="/c">the last one</a></p>`),
+		parseChapter(t, "https://example.com/broken", `<p>Never rendered.</p>`),
+		parseChapter(t, "https://example.com/c", `<p>The end.</p>`),
+	}
+
+	chapters, _ = maker.renderable(chapters, nil)
+	newLinkRewriter(chapters, canonical.Rules{}, false).rewrite(chapters)
+
+	content := chapters[0].article.Content
+	if !strings.Contains(content, `href="https://example.com/broken"`) || !strings.Contains(content, `href="chapter002.xhtml"`) {
+		t.Errorf("links should only point at chapters in the book:\n%s", content)
+	}
+}
@@ -0,0 +1,175 @@
+package epubgen
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/go-shiori/go-readability"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// markdownExts are the extensions of Markdown notes, which can't be told apart from plain text by their content
+var markdownExts = []string{".md", ".markdown"}
+
+// localKind returns the kind of the document at path: Markdown by its extension, HTML and text by their content.
+// It is "" for anything else.
+func localKind(path string, data []byte) string {
+	if slices.Contains(markdownExts, strings.ToLower(filepath.Ext(path))) {
+		return "markdown"
+	}
+	switch detected := mimetype.Detect(data); {
+	case detected.Is("text/html"), detected.Is("text/xml"):
+		return "html"
+	case detected.Is("text/plain"):
+		return "text"
+	}
+	return ""
+}
+
+// readLocal turns a local file into a chapter. Saved pages go through article extraction like fetched ones,
+// Markdown is rendered to HTML and plain text is split into paragraphs. Relative images point at files next
+// to the document.
+func readLocal(path string, imageWidth int) (chapter, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return chapter{}, err
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return chapter{}, err
+	}
+	base := &url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+	name := strings.TrimSuffix(filepath.Base(abs), filepath.Ext(abs))
+
+	var article readability.Article
+	switch localKind(abs, data) {
+	case "html":
+		article, err = localHTML(data, base, imageWidth)
+	case "markdown":
+		article, err = localMarkdown(data, base, imageWidth)
+	case "text":
+		article, err = localText(data)
+	default:
+		err = fmt.Errorf("%s is not an HTML, Markdown or text file", path)
+	}
+	if err != nil {
+		return chapter{}, err
+	}
+	if strings.TrimSpace(article.Title) == "" {
+		article.Title = name
+	}
+	return chapter{url: base.String(), article: article, localDir: filepath.Dir(abs)}, nil
+}
+
+func localHTML(data []byte, base *url.URL, imageWidth int) (readability.Article, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to parse the page: %w", err)
+	}
+	resolveImages(doc, base, imageWidth)
+	return readability.FromDocument(doc.Get(0), base)
+}
+
+// markdown renders notes with the GitHub extensions, HTML in a local file is the user's own and kept
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM, extension.Footnote),
+	goldmark.WithRendererOptions(gmhtml.WithUnsafe(), gmhtml.WithXHTML()),
+)
+
+// localMarkdown renders Markdown to HTML. The title comes from front matter, or from a leading level one
+// heading which is then left out since the chapter header shows it.
+func localMarkdown(data []byte, base *url.URL, imageWidth int) (readability.Article, error) {
+	fields, body := splitFrontMatter(data)
+	var rendered bytes.Buffer
+	if err := markdown.Convert(body, &rendered); err != nil {
+		return readability.Article{}, fmt.Errorf("failed to render Markdown: %w", err)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<div>" + rendered.String() + "</div>"))
+	if err != nil {
+		return readability.Article{}, err
+	}
+	content := doc.Find("body > div").First()
+	resolveImages(doc, base, imageWidth)
+
+	title := fields["title"]
+	if h1 := content.Children().First(); title == "" && h1.Is("h1") {
+		title = strings.TrimSpace(h1.Text())
+		h1.Remove()
+	}
+	return articleFrom(content, title, fields["byline"])
+}
+
+func localText(data []byte) (readability.Article, error) {
+	var b strings.Builder
+	b.WriteString("<div>")
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		// Lines of a paragraph are usually hard wrapped, they flow together again
+		if lines := strings.Fields(paragraph); len(lines) > 0 {
+			b.WriteString("<p>" + html.EscapeString(strings.Join(lines, " ")) + "</p>")
+		}
+	}
+	b.WriteString("</div>")
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(b.String()))
+	if err != nil {
+		return readability.Article{}, err
+	}
+	return articleFrom(doc.Find("body > div").First(), "", "")
+}
+
+// articleFrom describes content the way readability describes the articles it extracts
+func articleFrom(content *goquery.Selection, title, byline string) (readability.Article, error) {
+	markup, err := content.Html()
+	if err != nil {
+		return readability.Article{}, err
+	}
+	text := strings.TrimSpace(content.Text())
+	return readability.Article{
+		Title:       title,
+		Byline:      byline,
+		Node:        content.Get(0),
+		Content:     markup,
+		TextContent: text,
+		Length:      len(text),
+	}, nil
+}
+
+// splitFrontMatter separates a leading YAML front matter block from Markdown, returning its simple string fields
+func splitFrontMatter(data []byte) (map[string]string, []byte) {
+	fields := make(map[string]string)
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if !strings.HasPrefix(text, "---\n") {
+		return fields, data
+	}
+	block, rest, found := strings.Cut(text[len("---\n"):], "\n---\n")
+	if !found {
+		return fields, data
+	}
+	for _, line := range strings.Split(block, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(key, " ") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'`)
+		}
+		fields[strings.TrimSpace(key)] = value
+	}
+	return fields, []byte(rest)
+}
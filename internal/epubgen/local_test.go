@@ -0,0 +1,127 @@
+package epubgen
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadLocalMarkdown(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "figure.png", string(pngBytes(t, 8)))
+	path := writeFile(t, dir, "note.md", "---\ntitle: \"From front matter\"\nbyline: Ann\n---\n\n# Heading\n\nSome *text* with <kbd>Ctrl</kbd>.\n\n![figure](figure.png)\n")
+
+	c, err := readLocal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.article.Title != "From front matter" || c.article.Byline != "Ann" {
+		t.Errorf("title %q and byline %q, want them from the front matter", c.article.Title, c.article.Byline)
+	}
+	for _, want := range []string{"<h1>Heading</h1>", "<em>text</em>", "<kbd>Ctrl</kbd>"} {
+		if !strings.Contains(c.article.Content, want) {
+			t.Errorf("content is missing %q:\n%s", want, c.article.Content)
+		}
+	}
+	if newChapterPage(c).URL != "" {
+		t.Error("local document links back to its file")
+	}
+
+	out := newEpubOutput("Local", nil)
+	chapters := []chapter{c}
+	newImagePipeline(out, 1, 0, imaging.Profile{}).embed(context.Background(), chapters)
+	if !strings.Contains(chapters[0].article.Content, "../images/") {
+		t.Errorf("image next to the note was not embedded:\n%s", chapters[0].article.Content)
+	}
+}
+
+func TestReadLocalMarkdownHeadingTitle(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "note.md", "# The title\n\nBody\n")
+	c, err := readLocal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.article.Title != "The title" || strings.Contains(c.article.Content, "The title") {
+		t.Errorf("leading heading should become the title, got %q with content %s", c.article.Title, c.article.Content)
+	}
+}
+
+func TestReadLocalText(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "letter.txt", "Dear reader,\r\n\r\nthis line is\r\nhard wrapped & short.\n\n\n\nBye\n")
+	c, err := readLocal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.article.Title != "letter" {
+		t.Errorf("title is %q, want the file name", c.article.Title)
+	}
+	want := "<p>Dear reader,</p><p>this line is hard wrapped &amp; short.</p><p>Bye</p>"
+	if c.article.Content != want {
+		t.Errorf("content is %s, want %s", c.article.Content, want)
+	}
+}
+
+func TestReadLocalHTML(t *testing.T) {
+	paragraph := "<p>" + strings.Repeat("A saved article keeps its text, with commas, words and sentences. ", 20) + "</p>"
+	path := writeFile(t, t.TempDir(), "saved.html", "<html><head><title>Saved page</title></head><body><nav>Menu</nav><article>"+paragraph+paragraph+"</article></body></html>")
+	c, err := readLocal(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.article.Title != "Saved page" || !strings.Contains(c.article.TextContent, "A saved article") {
+		t.Errorf("article was not extracted: %q %q", c.article.Title, c.article.TextContent)
+	}
+}
+
+func TestLocalKind(t *testing.T) {
+	tests := []struct{ path, content, want string }{
+		{"notes.md", "<div>Markdown may open with HTML</div>", "markdown"},
+		{"saved", "<!DOCTYPE html><html><body>Saved</body></html>", "html"},
+		{"page.xhtml", "<?xml version=\"1.0\"?><html xmlns=\"http://www.w3.org/1999/xhtml\"></html>", "html"},
+		{"README", "Plain text without an extension", "text"},
+		{"image.txt", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", ""},
+	}
+	for _, tt := range tests {
+		if got := localKind(tt.path, []byte(tt.content)); got != tt.want {
+			t.Errorf("%s is read as %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLocalImagesStayInTheDocumentFolder(t *testing.T) {
+	root := t.TempDir()
+	private := writeFile(t, root, "private.png", string(pngBytes(t, 8)))
+	dir := filepath.Join(root, "notes")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inside := writeFile(t, dir, "figure.png", string(pngBytes(t, 8)))
+
+	p := newImagePipeline(newEpubOutput("Local", nil), 1, 0, imaging.Profile{})
+	ctx := context.Background()
+	if _, err := p.download(ctx, "file://"+filepath.ToSlash(inside), dir); err != nil {
+		t.Errorf("image next to the document was not read: %s", err)
+	}
+	for _, tt := range []struct{ src, dir string }{
+		{"file://" + filepath.ToSlash(inside), ""},
+		{"file://" + filepath.ToSlash(private), dir},
+		{"file://" + filepath.ToSlash(filepath.Join(dir, "..", "private.png")), dir},
+		{"file:///dev/zero", "/dev"},
+	} {
+		if _, err := p.download(ctx, tt.src, tt.dir); err == nil {
+			t.Errorf("%s was read for a document in %q", tt.src, tt.dir)
+		}
+	}
+}
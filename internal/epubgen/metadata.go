@@ -0,0 +1,264 @@
+package epubgen
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bmaupin/go-epub"
+	"github.com/gofrs/uuid"
+)
+
+// packageFile is where go-epub writes the package document holding the book metadata
+const packageFile = "EPUB/package.opf"
+
+const defaultLang = "en"
+
+// bookMetadata describes a book to library tools and Send-to-Kindle
+type bookMetadata struct {
+	author      string
+	lang        string
+	description string
+	identifier  string
+	date        time.Time
+	sources     []string
+}
+
+// newBookMetadata derives the metadata of a book from its chapters. Single article books take the author,
+// excerpt and publish date of their article, digests list their sites and titles and are dated now.
+func newBookMetadata(chapters []chapter, now time.Time) bookMetadata {
+	meta := bookMetadata{date: now, lang: bookLang(chapters)}
+
+	var urls []string
+	for _, c := range chapters {
+		urls = append(urls, c.url)
+	}
+	meta.sources = urls
+	meta.identifier = bookIdentifier(urls)
+
+	if len(chapters) == 1 {
+		article := chapters[0].article
+		meta.author = firstNonEmpty(strings.TrimSpace(article.Byline), strings.TrimSpace(article.SiteName), chapterHost(chapters[0]))
+		meta.description = strings.TrimSpace(article.Excerpt)
+		if article.PublishedTime != nil {
+			meta.date = *article.PublishedTime
+		}
+		return meta
+	}
+
+	var sites, titles []string
+	seen := make(map[string]bool)
+	for _, c := range chapters {
+		site := firstNonEmpty(strings.TrimSpace(c.article.SiteName), chapterHost(c))
+		if site != "" && !seen[strings.ToLower(site)] {
+			seen[strings.ToLower(site)] = true
+			sites = append(sites, site)
+		}
+		titles = append(titles, c.article.Title)
+	}
+	meta.author = strings.Join(sites, ", ")
+	meta.description = fmt.Sprintf("%d articles: %s", len(chapters), strings.Join(titles, "; "))
+	return meta
+}
+
+// apply sets the metadata go-epub knows about, date and sources are added by addPackageMetadata once the book is written
+func (m bookMetadata) apply(book *epub.Epub) {
+	if m.author != "" {
+		book.SetAuthor(m.author)
+	}
+	book.SetLang(m.lang)
+	if m.description != "" {
+		book.SetDescription(m.description)
+	}
+	book.SetIdentifier(m.identifier)
+}
+
+// bookIdentifier is a name based UUID of the set of urls, so the same links always make the same book
+func bookIdentifier(urls []string) string {
+	set := make([]string, 0, len(urls))
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		if !seen[u] {
+			seen[u] = true
+			set = append(set, u)
+		}
+	}
+	sort.Strings(set)
+	return "urn:uuid:" + uuid.NewV5(uuid.NamespaceURL, strings.Join(set, "\n")).String()
+}
+
+// bookLang is the language most chapters are written in, from the page's lang attribute or detected from the text
+func bookLang(chapters []chapter) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, c := range chapters {
+		lang := strings.TrimSpace(c.article.Language)
+		if lang == "" {
+			lang = detectLang(c.article.TextContent)
+		}
+		if lang == "" {
+			continue
+		}
+		counts[lang]++
+		if best == "" || counts[lang] > counts[best] {
+			best = lang
+		}
+	}
+	if best == "" {
+		return defaultLang
+	}
+	return best
+}
+
+// stopwords are frequent short words that tell common languages apart
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "with", "for", "was", "on", "are", "this"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "ein", "eine", "mit", "sich", "auf", "den", "auch", "ich"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "pas", "que", "pour", "dans", "qui", "sur", "du"},
+	"es": {"el", "la", "los", "las", "y", "que", "es", "por", "una", "para", "con", "del", "se", "como"},
+	"it": {"il", "di", "che", "e", "la", "per", "non", "una", "sono", "della", "del", "gli", "anche", "come"},
+	"pt": {"o", "os", "que", "e", "do", "da", "em", "um", "uma", "para", "com", "não", "dos", "mais"},
+	"nl": {"de", "het", "een", "en", "van", "is", "niet", "dat", "op", "te", "zijn", "voor", "met", "ook"},
+}
+
+// minLangHits is the fewest stopwords a text needs before its language is trusted
+const minLangHits = 5
+
+// detectLang guesses the language of text by counting stopwords, it returns "" when it can't tell
+func detectLang(text string) string {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) > 2000 {
+		words = words[:2000]
+	}
+	frequency := make(map[string]int, len(words))
+	for _, word := range words {
+		frequency[strings.Trim(word, ".,;:!?\"'()«»“”")]++
+	}
+
+	best, bestHits := "", 0
+	for lang, list := range stopwords {
+		hits := 0
+		for _, word := range list {
+			hits += frequency[word]
+		}
+		if hits > bestHits || (hits == bestHits && lang < best) {
+			best, bestHits = lang, hits
+		}
+	}
+	if bestHits < minLangHits {
+		return ""
+	}
+	return best
+}
+
+// addPackageMetadata adds dc:date and dc:source entries to a written epub, go-epub has no setters for them
+func addPackageMetadata(path string, meta bookMetadata) error {
+	var entries strings.Builder
+	entries.WriteString("    <dc:date>" + meta.date.UTC().Format(time.RFC3339) + "</dc:date>\n")
+	for _, source := range meta.sources {
+		entries.WriteString("    <dc:source>")
+		if err := xml.EscapeText(&entries, []byte(source)); err != nil {
+			return err
+		}
+		entries.WriteString("</dc:source>\n")
+	}
+
+	return rewriteZipFile(path, packageFile, func(opf []byte) ([]byte, error) {
+		end := bytes.Index(opf, []byte("</metadata>"))
+		if end < 0 {
+			return nil, fmt.Errorf("no metadata element in %s", packageFile)
+		}
+		// Keep the closing tag on its own indented line
+		lineStart := bytes.LastIndexByte(opf[:end], '\n') + 1
+		return append(opf[:lineStart:lineStart], append([]byte(entries.String()), opf[lineStart:]...)...), nil
+	})
+}
+
+// rewriteZipFile replaces the content of one file in a zip archive, copying the other files as they are.
+// Their order is kept, which matters for the mimetype file that has to come first in an epub.
+func rewriteZipFile(path, name string, edit func([]byte) ([]byte, error)) error {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".epub-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if info, err := os.Stat(path); err == nil {
+		if err := tmp.Chmod(info.Mode()); err != nil {
+			return err
+		}
+	}
+
+	writer := zip.NewWriter(tmp)
+	for _, f := range reader.File {
+		if f.Name != name {
+			if err := copyZipEntry(writer, f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if data, err = edit(data); err != nil {
+			return err
+		}
+		header := f.FileHeader
+		w, err := writer.CreateHeader(&header)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func copyZipEntry(writer *zip.Writer, f *zip.File) error {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	w, err := writer.CreateRaw(&f.FileHeader)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, raw)
+	return err
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package epubgen
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmaupin/go-epub"
+	"github.com/go-shiori/go-readability"
+)
+
+func TestBookMetadata(t *testing.T) {
+	published := time.Date(2023, time.July, 14, 8, 30, 0, 0, time.UTC)
+	now := time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC)
+
+	single := newBookMetadata([]chapter{{
+		url: "https://www.example.com/post",
+		article: readability.Article{
+			Title: "Post", Byline: "Jane Doe", SiteName: "Example", Excerpt: "An excerpt.",
+			Language: "en-GB", PublishedTime: &published,
+		},
+	}}, now)
+	if single.author != "Jane Doe" || single.description != "An excerpt." || single.lang != "en-GB" || !single.date.Equal(published) {
+		t.Errorf("single article metadata: %+v", single)
+	}
+
+	digest := newBookMetadata([]chapter{
+		{url: "https://example.com/a", article: readability.Article{Title: "A", SiteName: "Example", Byline: "Ann"}},
+		{url: "https://www.other.org/b", article: readability.Article{Title: "B", TextContent: strings.Repeat("der Hund und die Katze ist nicht da ", 5)}},
+		{url: "https://example.com/c", article: readability.Article{Title: "C", SiteName: "Example"}},
+	}, now)
+	if digest.author != "Example, other.org" {
+		t.Errorf("digest author %q", digest.author)
+	}
+	if digest.description != "3 articles: A; B; C" {
+		t.Errorf("digest description %q", digest.description)
+	}
+	if digest.lang != "de" {
+		t.Errorf("digest language %q, want the detected de", digest.lang)
+	}
+	if !digest.date.Equal(now) {
+		t.Errorf("digest dated %v, want now", digest.date)
+	}
+}
+
+func TestBookIdentifierIsStable(t *testing.T) {
+	a := bookIdentifier([]string{"https://a.com/1", "https://b.com/2"})
+	b := bookIdentifier([]string{"https://b.com/2", "https://a.com/1", "https://a.com/1"})
+	if a != b || !strings.HasPrefix(a, "urn:uuid:") {
+		t.Errorf("identifiers differ for the same urls: %s, %s", a, b)
+	}
+	if a == bookIdentifier([]string{"https://a.com/1"}) {
+		t.Error("different url sets share an identifier")
+	}
+}
+
+func TestAddPackageMetadata(t *testing.T) {
+	book := epub.NewEpub("Meta")
+	if _, err := book.AddSection("<p>text</p>", "Meta", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	meta := bookMetadata{
+		lang:       "en",
+		identifier: bookIdentifier([]string{"https://example.com/?a=1&b=2"}),
+		date:       time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC),
+		sources:    []string{"https://example.com/?a=1&b=2"},
+	}
+	meta.apply(book)
+
+	path := filepath.Join(t.TempDir(), "meta.epub")
+	if err := book.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := addPackageMetadata(path, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readZip(t, path)
+	opf := files[packageFile]
+	for _, want := range []string{
+		"<dc:date>2024-03-01T12:00:00Z</dc:date>",
+		"<dc:source>https://example.com/?a=1&amp;b=2</dc:source>",
+		meta.identifier,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("package document is missing %q:\n%s", want, opf)
+		}
+	}
+	if files["mimetype"] != "application/epub+zip" {
+		t.Error("mimetype file lost in the rewrite")
+	}
+}
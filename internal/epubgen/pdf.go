@@ -0,0 +1,167 @@
+package epubgen
+
+import (
+	"bytes"
+	"image"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const (
+	pdfFont     = "go"
+	pdfMonoFont = "gomono"
+	mmPerInch   = 25.4
+)
+
+// pdfPageSize is used for devices without a known screen size
+var pdfPageSize = pdfPage{width: 148, height: 210, margin: 12, ppi: 150}
+
+// pdfPage is the geometry of the pages of a PDF, in millimeters
+type pdfPage struct {
+	width, height, margin float64
+	// ppi converts the pixel size of images to their printed size, so they show at their prepared resolution
+	ppi float64
+}
+
+// pageFor sizes pages to the screen of the device, so a page fills the screen without zooming
+func pageFor(profile imaging.Profile) pdfPage {
+	if profile.PPI <= 0 || profile.MaxWidth <= 0 || profile.MaxHeight <= 0 {
+		page := pdfPageSize
+		if profile.MarginMM > 0 {
+			page.margin = profile.MarginMM
+		}
+		return page
+	}
+	ppi := float64(profile.PPI)
+	return pdfPage{
+		width:  float64(profile.MaxWidth) / ppi * mmPerInch,
+		height: float64(profile.MaxHeight) / ppi * mmPerInch,
+		margin: profile.MarginMM,
+		ppi:    ppi,
+	}
+}
+
+// fontSize is the body text size in points, small screens get a smaller size that still fits a line of text
+func (p pdfPage) fontSize() float64 {
+	return min(12, max(9, (p.width-2*p.margin)/9))
+}
+
+// pdfSection is a page of the book waiting to be laid out
+type pdfSection struct {
+	title, filename, body string
+}
+
+// pdfOutput lays books out as PDF. Images are registered as they come, pages are laid out when the book is
+// written so the contents page can link to chapters that follow it.
+type pdfOutput struct {
+	pdf      *gofpdf.Fpdf
+	page     pdfPage
+	title    string
+	cover    string
+	sections []pdfSection
+	// images holds the pixel size of every registered image by name
+	images map[string]image.Point
+}
+
+func newPDFOutput(title string, profile imaging.Profile) *pdfOutput {
+	page := pageFor(profile)
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		OrientationStr: "P",
+		UnitStr:        "mm",
+		Size:           gofpdf.SizeType{Wd: page.width, Ht: page.height},
+	})
+	pdf.SetMargins(page.margin, page.margin, page.margin)
+	pdf.SetAutoPageBreak(true, page.margin)
+	pdf.AddUTF8FontFromBytes(pdfFont, "", goregular.TTF)
+	pdf.AddUTF8FontFromBytes(pdfFont, "B", gobold.TTF)
+	pdf.AddUTF8FontFromBytes(pdfFont, "I", goitalic.TTF)
+	pdf.AddUTF8FontFromBytes(pdfFont, "BI", gobolditalic.TTF)
+	pdf.AddUTF8FontFromBytes(pdfMonoFont, "", gomono.TTF)
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("kindle-send", true)
+	return &pdfOutput{pdf: pdf, page: page, title: title, images: make(map[string]image.Point)}
+}
+
+// addImage registers the image under its name, which chapters then use as its src
+func (o *pdfOutput) addImage(name string, img embeddedImage) (string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(img.data))
+	if err != nil {
+		return "", err
+	}
+	o.pdf.RegisterImageOptionsReader(name, gofpdf.ImageOptions{ImageType: pdfImageType(img)}, bytes.NewReader(img.data))
+	if err := o.pdf.Error(); err != nil {
+		// gofpdf stops doing anything after an error, only this image is left out
+		o.pdf.ClearError()
+		return "", err
+	}
+	o.images[name] = image.Pt(config.Width, config.Height)
+	return name, nil
+}
+
+func (o *pdfOutput) setCover(img embeddedImage) error {
+	name, err := o.addImage("cover"+img.ext(), img)
+	if err != nil {
+		return err
+	}
+	o.cover = name
+	return nil
+}
+
+func (o *pdfOutput) addPage(title, filename, body string) error {
+	o.sections = append(o.sections, pdfSection{title: title, filename: filename, body: body})
+	return nil
+}
+
+func (o *pdfOutput) linksPages() bool {
+	return true
+}
+
+func (o *pdfOutput) write(path string, meta bookMetadata) error {
+	if meta.author != "" {
+		o.pdf.SetAuthor(meta.author, true)
+	}
+	if meta.description != "" {
+		o.pdf.SetSubject(meta.description, true)
+	}
+	if len(meta.sources) > 0 {
+		o.pdf.SetKeywords(strings.Join(meta.sources, " "), true)
+	}
+
+	if o.cover != "" {
+		o.drawCover()
+	}
+	r := newPDFRenderer(o)
+	for _, section := range o.sections {
+		r.section(section)
+	}
+	r.finish()
+	return o.pdf.OutputFileAndClose(path)
+}
+
+// drawCover fills the first page with the cover, keeping its aspect ratio
+func (o *pdfOutput) drawCover() {
+	o.pdf.AddPage()
+	size := o.images[o.cover]
+	scale := min(o.page.width/float64(size.X), o.page.height/float64(size.Y))
+	w, h := float64(size.X)*scale, float64(size.Y)*scale
+	o.pdf.ImageOptions(o.cover, (o.page.width-w)/2, (o.page.height-h)/2, w, h, false, gofpdf.ImageOptions{}, 0, "")
+}
+
+// pdfImageType names the image type for gofpdf, which would otherwise guess it from the name
+func pdfImageType(img embeddedImage) string {
+	switch img.mime {
+	case "image/jpeg":
+		return "JPG"
+	case "image/gif":
+		return "GIF"
+	default:
+		return "PNG"
+	}
+}
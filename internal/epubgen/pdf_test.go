@@ -0,0 +1,122 @@
+package epubgen
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/go-readability"
+	"github.com/ryan-gang/kindle-send-daemon/internal/imaging"
+)
+
+func TestPDFOutput(t *testing.T) {
+	out := newPDFOutput("Printed", imaging.Profiles["kindle"])
+	maker := &bookmaker{out: out, format: FormatPDF, layout: loadLayout("")}
+
+	ref, err := out.addImage("img1.png", embeddedImage{data: pngBytes(t, 600), mime: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.setCover(embeddedImage{data: pngBytes(t, 40), mime: "image/png"}); err != nil {
+		t.Fatal(err)
+	}
+
+	chapters := []chapter{
+		{url: "https://example.com/a", article: readability.Article{Title: "First", Byline: "Ann", TextContent: "one two",
+			Content: `<h2>Intro</h2><p>Text with <b>bold</b>, <i>italic</i>, <code>code</code> and a <a href="chapter002.xhtml">link</a>.</p>` +
+				`<img src="` + ref + `"><ul><li><p>first</p></li><li>second</li></ul><ol><li>one</li></ol>` +
+				`<blockquote>Quoted ünïcödé</blockquote><pre>line 1
+line 2</pre><p>See<sup><a href="#footnote-1">[1]</a></sup></p><ol><li id="footnote-1">https://example.org</li></ol>`}},
+		{url: "https://example.com/b", article: readability.Article{Title: "Second", Content: "<p>b</p>"}},
+	}
+	failed := []fetched{{url: "https://example.net/missing", err: errors.New("404 Not Found")}}
+	if err := maker.addContent("Printed", chapters, failed); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "printed.pdf")
+	if err := out.write(path, bookMetadata{author: "Ann", sources: []string{"https://example.com/a"}, date: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Fatal("written file is not a PDF")
+	}
+	// The cover, the contents, two chapters and the failed pages
+	if pages := out.pdf.PageCount(); pages < 5 {
+		t.Errorf("%d pages, want at least 5", pages)
+	}
+	if !bytes.Contains(data, []byte("/Outlines")) {
+		t.Error("PDF has no outline")
+	}
+}
+
+func TestPageFor(t *testing.T) {
+	page := pageFor(imaging.Profiles["remarkable"])
+	if math.Abs(page.width-157.8) > 0.1 || math.Abs(page.height-210.4) > 0.1 || page.margin != 10 {
+		t.Errorf("remarkable page is %.1f x %.1f mm with %.0f mm margins", page.width, page.height, page.margin)
+	}
+	if page := pageFor(imaging.Profiles["original"]); page != pdfPageSize {
+		t.Errorf("device without a screen size got %+v, want the default page", page)
+	}
+}
+
+// png16Bytes encodes a 16-bit PNG, which gofpdf can't embed
+func png16Bytes(t *testing.T) []byte {
+	img := image.NewNRGBA64(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.NRGBA64{R: 0x1234, A: 0xffff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPDFOutputSkipsFailedImage(t *testing.T) {
+	out := newPDFOutput("Printed", imaging.Profile{})
+	if _, err := out.addImage("bad.png", embeddedImage{data: png16Bytes(t), mime: "image/png"}); err == nil {
+		t.Fatal("16-bit PNG was registered")
+	}
+	ref, err := out.addImage("good.png", embeddedImage{data: pngBytes(t, 20), mime: "image/png"})
+	if err != nil {
+		t.Fatalf("image after a failed one was not registered: %s", err)
+	}
+
+	maker := &bookmaker{out: out, format: FormatPDF, layout: loadLayout("")}
+	chapters := []chapter{{url: "https://example.com/a", article: readability.Article{Title: "First",
+		Content: `<p>Text</p><img src="bad.png"><img src="` + ref + `">`}}}
+	if err := maker.addContent("Printed", chapters, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.write(filepath.Join(t.TempDir(), "printed.pdf"), bookMetadata{date: time.Now()}); err != nil {
+		t.Fatalf("one failed image broke the PDF: %s", err)
+	}
+}
+
+func TestEncodePNGIs8Bit(t *testing.T) {
+	img, _, err := image.Decode(bytes.NewReader(png16Bytes(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := encodePNG(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bit depth follows the width and height in the IHDR chunk
+	if depth := encoded.data[24]; depth != 8 {
+		t.Errorf("PNG has %d bits per channel, want 8", depth)
+	}
+	if _, err := newPDFOutput("Printed", imaging.Profile{}).addImage("img.png", encoded); err != nil {
+		t.Errorf("converted PNG can't go into a PDF: %s", err)
+	}
+}
@@ -0,0 +1,403 @@
+package epubgen
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	pdfLineSpacing = 1.4
+	// pointMM converts font sizes to millimeters
+	pointMM = mmPerInch / 72
+	// pdfIndent is how far lists and quotes are indented, in millimeters
+	pdfIndent = 5
+)
+
+// pdfStyle is the text style in effect while walking the HTML of a page
+type pdfStyle struct {
+	bold, italic, mono, pre bool
+	scale                   float64
+	gray                    int
+	href                    string
+}
+
+// pdfRenderer lays out the XHTML pages of a book with gofpdf. It knows the common article elements, the rest of
+// the markup only contributes its text.
+type pdfRenderer struct {
+	o     *pdfOutput
+	pdf   *gofpdf.Fpdf
+	style pdfStyle
+	// indent is the extra left margin of the current block
+	indent float64
+	// lists holds the next number of every open ordered list, 0 for unordered lists
+	lists []int
+	// file is the section being laid out, fragments of its links are relative to it
+	file string
+	// links are the internal link targets by file and fragment, set tracks which ones were reached
+	links map[string]int
+	set   map[int]bool
+	// space is true after whitespace was written, so collapsed whitespace doesn't double up
+	space bool
+	// marker is true while only a list marker was written on the line, the item's first block goes next to it
+	marker bool
+}
+
+func newPDFRenderer(o *pdfOutput) *pdfRenderer {
+	return &pdfRenderer{
+		o:     o,
+		pdf:   o.pdf,
+		style: pdfStyle{scale: 1},
+		links: make(map[string]int),
+		set:   make(map[int]bool),
+	}
+}
+
+// section lays out a page of the book starting on a new PDF page, and adds it to the outline
+func (r *pdfRenderer) section(s pdfSection) {
+	r.file = s.filename
+	r.pdf.AddPage()
+	r.apply()
+	r.pdf.Bookmark(s.title, 0, -1)
+	r.target(s.filename)
+
+	nodes, err := html.ParseFragment(strings.NewReader(s.body), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+	if err != nil {
+		r.text(s.title)
+		return
+	}
+	r.space = true
+	for _, n := range nodes {
+		r.walk(n)
+	}
+}
+
+// finish points links whose target never showed up at the first page, gofpdf can't write links without a page
+func (r *pdfRenderer) finish() {
+	for _, link := range r.links {
+		if !r.set[link] {
+			r.pdf.SetLink(link, 0, 1)
+		}
+	}
+}
+
+// link returns the internal link for a file or fragment of the book, creating it on first use
+func (r *pdfRenderer) link(key string) int {
+	if link, ok := r.links[key]; ok {
+		return link
+	}
+	link := r.pdf.AddLink()
+	r.links[key] = link
+	return link
+}
+
+// target makes key point at the current position
+func (r *pdfRenderer) target(key string) {
+	link := r.link(key)
+	r.pdf.SetLink(link, -1, -1)
+	r.set[link] = true
+}
+
+func (r *pdfRenderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.text(n.Data)
+	case html.ElementNode:
+		if id := attr(n, "id"); id != "" {
+			r.target(r.file + "#" + id)
+		}
+		r.element(n)
+	}
+}
+
+func (r *pdfRenderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.walk(c)
+	}
+}
+
+// styled walks the children of n in a style derived from the current one
+func (r *pdfRenderer) styled(n *html.Node, change func(*pdfStyle)) {
+	saved := r.style
+	change(&r.style)
+	r.apply()
+	r.children(n)
+	r.style = saved
+	r.apply()
+}
+
+// block lays out n on lines of its own, with gap millimeters above and below
+func (r *pdfRenderer) block(n *html.Node, gap float64, change func(*pdfStyle)) {
+	r.breakLine()
+	r.skip(gap)
+	r.styled(n, change)
+	r.breakLine()
+	r.skip(gap)
+}
+
+func (r *pdfRenderer) element(n *html.Node) {
+	gap := r.lineHeight() * 0.5
+	switch n.Data {
+	case "script", "style", "noscript", "template", "svg", "video", "audio", "iframe", "object", "button", "form", "head":
+	case "br":
+		r.pdf.Ln(r.lineHeight())
+		r.space = true
+	case "img":
+		r.image(n)
+	case "hr":
+		r.breakLine()
+		r.skip(gap)
+		left, _, right, _ := r.pdf.GetMargins()
+		y := r.pdf.GetY()
+		r.pdf.SetDrawColor(160, 160, 160)
+		r.pdf.Line(left, y, r.o.page.width-right, y)
+		r.skip(gap)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		scale := map[string]float64{"h1": 1.6, "h2": 1.35, "h3": 1.2}[n.Data]
+		r.block(n, gap, func(s *pdfStyle) {
+			s.bold = true
+			s.scale = max(scale, 1.05)
+		})
+	case "p", "div", "section", "article", "header", "footer", "aside", "main", "nav", "figure", "figcaption",
+		"details", "summary", "dl", "dt", "table", "caption", "address":
+		r.block(n, gap, func(s *pdfStyle) {
+			switch {
+			case n.Data == "figcaption" || n.Data == "caption":
+				s.italic, s.scale = true, 0.9
+			case n.Data == "dt":
+				s.bold = true
+			case hasClass(n, "byline", "meta", "source", "footnotes"):
+				// the header lines of the chapter template and the link list closing chapters
+				s.scale, s.gray = 0.85, 80
+			}
+		})
+	case "tr":
+		r.breakLine()
+		r.children(n)
+		r.breakLine()
+	case "td", "th":
+		r.styled(n, func(s *pdfStyle) { s.bold = s.bold || n.Data == "th" })
+		r.text(" ")
+	case "blockquote", "dd":
+		r.indented(n, gap, func(s *pdfStyle) { s.italic = n.Data == "blockquote" })
+	case "ul", "ol":
+		next := 0
+		if n.Data == "ol" {
+			next = 1
+		}
+		r.lists = append(r.lists, next)
+		r.indented(n, gap/2, func(*pdfStyle) {})
+		r.lists = r.lists[:len(r.lists)-1]
+	case "li":
+		r.listItem(n)
+	case "pre":
+		r.block(n, gap, func(s *pdfStyle) {
+			s.mono, s.pre, s.scale = true, true, 0.85
+		})
+	case "b", "strong":
+		r.styled(n, func(s *pdfStyle) { s.bold = true })
+	case "i", "em", "cite", "var", "dfn":
+		r.styled(n, func(s *pdfStyle) { s.italic = true })
+	case "code", "kbd", "samp", "tt":
+		r.styled(n, func(s *pdfStyle) { s.mono = true })
+	case "small", "sup", "sub":
+		r.styled(n, func(s *pdfStyle) { s.scale *= 0.8 })
+	case "a":
+		r.styled(n, func(s *pdfStyle) { s.href = attr(n, "href") })
+	default:
+		r.children(n)
+	}
+}
+
+// indented lays out n as a block moved right by pdfIndent
+func (r *pdfRenderer) indented(n *html.Node, gap float64, change func(*pdfStyle)) {
+	r.indent += pdfIndent
+	r.applyIndent()
+	r.block(n, gap, change)
+	r.indent -= pdfIndent
+	r.applyIndent()
+}
+
+// listItem starts a line with the bullet or number of the item, hanging in the list's indent
+func (r *pdfRenderer) listItem(n *html.Node) {
+	r.breakLine()
+	marker := "•"
+	if len(r.lists) > 0 && r.lists[len(r.lists)-1] > 0 {
+		marker = strconv.Itoa(r.lists[len(r.lists)-1]) + "."
+		r.lists[len(r.lists)-1]++
+	}
+	left, _, _, _ := r.pdf.GetMargins()
+	r.pdf.SetX(left - pdfIndent)
+	r.pdf.CellFormat(pdfIndent, r.lineHeight(), marker, "", 0, "L", false, 0, "")
+	r.space, r.marker = true, true
+	r.children(n)
+	r.marker = false
+	r.breakLine()
+}
+
+// image draws a registered image centered on its own line, no larger than its pixel size at the screen's density.
+// Images that were not embedded are left out.
+func (r *pdfRenderer) image(n *html.Node) {
+	src := attr(n, "src")
+	size, ok := r.o.images[src]
+	if !ok || size.X == 0 || size.Y == 0 {
+		return
+	}
+	r.breakLine()
+
+	page := r.o.page
+	left, top, right, bottom := r.pdf.GetMargins()
+	maxWidth := page.width - left - right
+	maxHeight := page.height - top - bottom
+	w := float64(size.X) / page.ppi * mmPerInch
+	h := float64(size.Y) / page.ppi * mmPerInch
+	if scale := min(1, maxWidth/w, maxHeight/h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	if r.pdf.GetY()+h > page.height-bottom {
+		r.pdf.AddPage()
+	}
+	y := r.pdf.GetY()
+	r.pdf.ImageOptions(src, left+(maxWidth-w)/2, y, w, h, false, gofpdf.ImageOptions{}, 0, "")
+	r.pdf.SetY(y + h)
+	r.skip(r.lineHeight() * 0.5)
+	r.space = true
+}
+
+// text writes inline text, collapsing whitespace outside of preformatted blocks
+func (r *pdfRenderer) text(s string) {
+	if r.style.pre {
+		for i, line := range strings.Split(s, "\n") {
+			if i > 0 {
+				r.pdf.Ln(r.lineHeight())
+			}
+			r.write(line)
+		}
+		return
+	}
+
+	leading := strings.IndexFunc(s, isSpace) == 0
+	trailing := s != "" && isSpace(rune(s[len(s)-1]))
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if leading && !r.space {
+			r.write(" ")
+			r.space = true
+		}
+		return
+	}
+	out := strings.Join(words, " ")
+	if leading && !r.space {
+		out = " " + out
+	}
+	if trailing {
+		out += " "
+	}
+	r.write(out)
+	r.space = trailing
+}
+
+// write outputs text in the current style, as a link when inside one
+func (r *pdfRenderer) write(s string) {
+	r.marker = false
+	h := r.lineHeight()
+	href := r.style.href
+	switch {
+	case href == "":
+		r.pdf.Write(h, s)
+	case strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://"):
+		r.pdf.WriteLinkString(h, s, href)
+	default:
+		r.pdf.WriteLinkID(h, s, r.link(r.resolve(href)))
+	}
+}
+
+// resolve turns a link inside the book into its key, fragments alone are relative to the current section
+func (r *pdfRenderer) resolve(href string) string {
+	if strings.HasPrefix(href, "#") {
+		return r.file + href
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		return unescaped
+	}
+	return href
+}
+
+// breakLine ends the current line unless nothing was written on it yet
+func (r *pdfRenderer) breakLine() {
+	if r.marker {
+		return
+	}
+	left, _, _, _ := r.pdf.GetMargins()
+	if r.pdf.GetX() > left+0.01 {
+		r.pdf.Ln(r.lineHeight())
+	}
+	r.space = true
+}
+
+// skip adds vertical space, except at the top of a page
+func (r *pdfRenderer) skip(gap float64) {
+	if r.marker {
+		return
+	}
+	_, top, _, _ := r.pdf.GetMargins()
+	if y := r.pdf.GetY(); y > top+0.01 {
+		r.pdf.SetY(y + gap)
+	}
+}
+
+func (r *pdfRenderer) lineHeight() float64 {
+	return r.o.page.fontSize() * r.style.scale * pointMM * pdfLineSpacing
+}
+
+// apply sets the font and color of the current style
+func (r *pdfRenderer) apply() {
+	family, variant := pdfFont, ""
+	if r.style.mono {
+		family = pdfMonoFont
+	} else {
+		if r.style.bold {
+			variant += "B"
+		}
+		if r.style.italic {
+			variant += "I"
+		}
+	}
+	if r.style.href != "" {
+		variant += "U"
+	}
+	r.pdf.SetFont(family, variant, r.o.page.fontSize()*r.style.scale)
+	r.pdf.SetTextColor(r.style.gray, r.style.gray, r.style.gray)
+}
+
+func (r *pdfRenderer) applyIndent() {
+	r.pdf.SetLeftMargin(r.o.page.margin + r.indent)
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, names ...string) bool {
+	for _, class := range strings.Fields(attr(n, "class")) {
+		for _, name := range names {
+			if class == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
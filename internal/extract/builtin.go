@@ -0,0 +1,41 @@
+package extract
+
+// builtin are the rules for popular sites readability extracts badly, user rules take precedence over them
+var builtin = []struct {
+	pattern string
+	content string
+	remove  []string
+}{
+	{
+		// Subscribe and share widgets are scattered through posts
+		pattern: "*.substack.com",
+		content: ".available-content .body, .body.markup",
+		remove:  []string{".subscription-widget-wrap", ".subscribe-widget", ".button-wrapper", ".share-dialog", ".post-footer", ".footnote-anchor-web"},
+	},
+	{
+		// Claps, follow buttons and member-only banners end up in the text
+		pattern: "*.medium.com",
+		content: "article section",
+		remove:  []string{"[data-testid=headerSocialShareButton]", ".speechify-ignore", ".pw-multi-vote-icon", "[aria-label=responses]"},
+	},
+	{
+		// Readability picks the file list instead of the README
+		pattern: "github.com",
+		content: "article.markdown-body",
+		remove:  []string{".anchor", ".octicon"},
+	},
+	{
+		// Abstract pages are mostly navigation, keep the title, authors and abstract
+		pattern: "arxiv.org",
+		content: "#abs",
+		remove:  []string{".extra-services", ".submission-history", ".endorsers", ".dateline", ".button", ".mobile-submission-download"},
+	},
+	{
+		pattern: "*.wikipedia.org",
+		content: "#mw-content-text .mw-parser-output",
+		remove: []string{
+			".mw-editsection", ".navbox", ".vertical-navbox", ".sistersitebox", ".ambox", ".hatnote",
+			".noprint", ".reference", ".mw-references-wrap", "#toc", ".toc", ".mw-empty-elt",
+		},
+	},
+}
@@ -0,0 +1,139 @@
+package extract
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/go-shiori/go-readability"
+)
+
+// Extractor pulls the readable article out of a fetched page. Extractors may modify doc.
+type Extractor interface {
+	Extract(doc *goquery.Document, pageURL *url.URL) (readability.Article, error)
+}
+
+// Readability is the generic extractor, used for every site without a rule
+type Readability struct{}
+
+func (Readability) Extract(doc *goquery.Document, pageURL *url.URL) (readability.Article, error) {
+	return readability.FromDocument(doc.Get(0), pageURL)
+}
+
+// Selector extracts sites readability gets wrong with CSS selectors. Elements matching the remove selectors are
+// dropped first, then the first element matching the content selector becomes the article. Without a content
+// selector, or when it matches nothing, readability extracts the cleaned page. The title, byline and other
+// metadata always come from readability.
+type Selector struct {
+	content cascadia.Selector
+	remove  cascadia.Selector
+}
+
+// elements no article body needs, removed from selected content since readability doesn't clean it
+const unwanted = "script, style, noscript, iframe, form, button, input, select, textarea"
+
+// NewSelector compiles the selectors of a rule, content and remove may be empty
+func NewSelector(content string, remove []string) (Selector, error) {
+	var s Selector
+	var err error
+	if strings.TrimSpace(content) != "" {
+		if s.content, err = cascadia.Compile(content); err != nil {
+			return Selector{}, fmt.Errorf("invalid content selector %q: %w", content, err)
+		}
+	}
+	if len(remove) > 0 {
+		group := strings.Join(remove, ", ")
+		if s.remove, err = cascadia.Compile(group); err != nil {
+			return Selector{}, fmt.Errorf("invalid remove selectors %q: %w", group, err)
+		}
+	}
+	return s, nil
+}
+
+func (s Selector) Extract(doc *goquery.Document, pageURL *url.URL) (readability.Article, error) {
+	if s.remove != nil {
+		doc.FindMatcher(s.remove).Remove()
+	}
+
+	article, err := readability.FromDocument(doc.Get(0), pageURL)
+	if s.content == nil {
+		return article, err
+	}
+	content := doc.FindMatcher(s.content).First()
+	if content.Length() == 0 {
+		return article, err
+	}
+
+	content.Find(unwanted).Remove()
+	content.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		if resolved, err := pageURL.Parse(strings.TrimSpace(href)); err == nil && !strings.HasPrefix(href, "#") {
+			a.SetAttr("href", resolved.String())
+		}
+	})
+
+	html, htmlErr := content.Html()
+	if htmlErr != nil {
+		return article, err
+	}
+	if article.Title == "" {
+		article.Title = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+	article.Node = content.Get(0)
+	article.Content = html
+	article.TextContent = strings.TrimSpace(content.Text())
+	article.Length = len(article.TextContent)
+	return article, nil
+}
+
+// Registry picks the extractor for a page by its host
+type Registry struct {
+	rules    []rule
+	fallback Extractor
+}
+
+type rule struct {
+	pattern   string
+	extractor Extractor
+}
+
+// NewRegistry returns a registry with the built-in site rules and readability as the fallback
+func NewRegistry() *Registry {
+	r := &Registry{fallback: Readability{}}
+	for _, b := range builtin {
+		selector, err := NewSelector(b.content, b.remove)
+		if err != nil {
+			panic(err)
+		}
+		r.Register(b.pattern, selector)
+	}
+	return r
+}
+
+// Register adds an extractor for hosts matching pattern, taking precedence over the rules registered before it.
+// See MatchHost for the pattern syntax.
+func (r *Registry) Register(pattern string, e Extractor) {
+	r.rules = append([]rule{{pattern: strings.ToLower(strings.TrimSpace(pattern)), extractor: e}}, r.rules...)
+}
+
+// For returns the extractor to use for host, given without a port
+func (r *Registry) For(host string) Extractor {
+	for _, rule := range r.rules {
+		if MatchHost(rule.pattern, host) {
+			return rule.extractor
+		}
+	}
+	return r.fallback
+}
+
+// MatchHost reports whether host matches pattern. "example.com" matches example.com and www.example.com,
+// "*.example.com" matches example.com and all of its subdomains.
+func MatchHost(pattern, host string) bool {
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+	return host == strings.TrimPrefix(pattern, "www.")
+}
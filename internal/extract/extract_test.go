@@ -0,0 +1,113 @@
+package extract
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const page = `<html><head><title>Release notes</title><meta name="author" content="Jane Doe"></head><body>
+	<nav><a href="/">Home</a> <a href="/about">About</a></nav>
+	<div class="post">
+		<p>The first paragraph of the post, which is what the reader came for. <a href="/docs">Docs</a></p>
+		<div class="ad">Buy things</div>
+		<p>The second paragraph of the post goes on about the release in some more detail.</p>
+		<script>track()</script>
+	</div>
+	<aside class="comments"><p>A long comment that readability might mistake for content, it goes on and on and on.</p></aside>
+</body></html>`
+
+func parse(t *testing.T) *goquery.Document {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestSelector(t *testing.T) {
+	selector, err := NewSelector("div.post", []string{".ad", "nav"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageURL, _ := url.Parse("https://example.com/blog/release")
+	article, err := selector.Extract(parse(t), pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if article.Title != "Release notes" {
+		t.Errorf("title %q", article.Title)
+	}
+	for _, unwanted := range []string{"Buy things", "track()", "A long comment"} {
+		if strings.Contains(article.Content, unwanted) {
+			t.Errorf("content still has %q:\n%s", unwanted, article.Content)
+		}
+	}
+	if !strings.Contains(article.Content, `href="https://example.com/docs"`) {
+		t.Errorf("relative link not resolved:\n%s", article.Content)
+	}
+	if !strings.HasPrefix(article.TextContent, "The first paragraph") {
+		t.Errorf("text content %q", article.TextContent)
+	}
+}
+
+func TestSelectorFallsBackToReadability(t *testing.T) {
+	selector, err := NewSelector("div.missing", []string{".comments"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageURL, _ := url.Parse("https://example.com/blog/release")
+	article, err := selector.Extract(parse(t), pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(article.TextContent, "The first paragraph") || strings.Contains(article.TextContent, "A long comment") {
+		t.Errorf("unexpected readability content %q", article.TextContent)
+	}
+
+	if _, err := NewSelector("div[", nil); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	if _, ok := registry.For("example.com").(Readability); !ok {
+		t.Error("unknown hosts should use readability")
+	}
+	if _, ok := registry.For("en.wikipedia.org").(Selector); !ok {
+		t.Error("wikipedia should use its built-in rule")
+	}
+
+	custom, _ := NewSelector("main", nil)
+	registry.Register("*.example.com", custom)
+	registry.Register("en.wikipedia.org", Readability{})
+	if _, ok := registry.For("blog.example.com").(Selector); !ok {
+		t.Error("registered rule not used for a subdomain")
+	}
+	if _, ok := registry.For("en.wikipedia.org").(Readability); !ok {
+		t.Error("later rules should take precedence over built-in ones")
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"github.com", "github.com", true},
+		{"github.com", "www.github.com", true},
+		{"github.com", "gist.github.com", false},
+		{"*.substack.com", "astralcodexten.substack.com", true},
+		{"*.substack.com", "substack.com", true},
+		{"*.substack.com", "notsubstack.com", false},
+	}
+	for _, tt := range tests {
+		if got := MatchHost(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("MatchHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
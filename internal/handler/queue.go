@@ -1,54 +1,151 @@
 package handler
 
 import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
 	"github.com/ryan-gang/kindle-send-daemon/internal/config"
 	"github.com/ryan-gang/kindle-send-daemon/internal/epubgen"
+	"github.com/ryan-gang/kindle-send-daemon/internal/linkfile"
 	"github.com/ryan-gang/kindle-send-daemon/internal/mail"
 	"github.com/ryan-gang/kindle-send-daemon/internal/types"
 	"github.com/ryan-gang/kindle-send-daemon/internal/util"
 )
 
-func Queue(downloadRequests []types.Request) []types.Request {
-	var processedRequests []types.Request
+// Download turns a single request into a file ready to be mailed
+func Download(ctx context.Context, req types.Request) types.Result {
+	result := types.Result{Request: req}
+
+	var book epubgen.Book
+	var err error
+	switch req.Type {
+	case types.TypeFile:
+		book = epubgen.Book{Path: req.Path, Title: filepath.Base(req.Path)}
+	case types.TypeUrl:
+		book, err = makeBook(ctx, req, []string{req.Path})
+	case types.TypeUrlFile:
+		var links []string
+		if links, err = linkfile.Read(req.Path); err == nil {
+			book, err = makeBook(ctx, req, links)
+		}
+	case types.TypeLocalDocument:
+		book, err = makeBook(ctx, req, nil)
+	default:
+		err = fmt.Errorf("unsupported request type %s", req.Type)
+	}
+	if err != nil {
+		result.Status = types.StatusDownloadFailed
+		result.Err = err
+		return result
+	}
+
+	size, err := diskSize(book.Path)
+	if err != nil {
+		result.Status = types.StatusDownloadFailed
+		result.Err = fmt.Errorf("couldn't access %s: %w", book.Path, err)
+		return result
+	}
+
+	result.Path = book.Path
+	result.Title = book.Title
+	result.Size = size
+	result.Fingerprint = book.Fingerprint
+	result.Status = types.StatusDownloaded
+	return result
+}
+
+// diskSize is the size of a file, or of all the files in a folder of exported articles
+func diskSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// makeBook generates the book for the urls of a request, or for its local document, in the format the request
+// asks for or the configured one
+func makeBook(ctx context.Context, req types.Request, urls []string) (epubgen.Book, error) {
+	name, configured := req.Options[types.OptionFormat], false
+	if cfg := config.GetInstance(); name == "" && cfg != nil {
+		name, configured = cfg.OutputFormat, true
+	}
+	format, err := epubgen.ParseFormat(name)
+	if err != nil {
+		return epubgen.Book{}, err
+	}
+	// Exports are not books, they can only be asked for when downloading
+	if configured && format.Export() {
+		return epubgen.Book{}, fmt.Errorf("output_format %s is not a book format, use one of %s", format, epubgen.FormatNames(epubgen.BookFormats))
+	}
+	if req.Type == types.TypeLocalDocument {
+		return epubgen.MakeLocal(ctx, req.Path, format)
+	}
+	return epubgen.Make(ctx, urls, "", format)
+}
+
+// Queue downloads every request, returning one result per request in the same order
+func Queue(ctx context.Context, downloadRequests []types.Request) []types.Result {
+	results := make([]types.Result, 0, len(downloadRequests))
 	for _, req := range downloadRequests {
-		switch req.Type {
-		case types.TypeFile:
-			processedRequests = append(processedRequests, req)
-			continue
-		case types.TypeUrl:
-			path, err := epubgen.Make([]string{req.Path}, "")
-			if err != nil {
-				util.Red.Printf("SKIPPING %s : %s\n", req.Path, err)
-			} else {
-				processedRequests = append(processedRequests, types.NewRequest(path, types.TypeFile, nil))
-			}
-		case types.TypeUrlFile:
-			links := util.ExtractLinks(req.Path)
-			path, err := epubgen.Make(links, "")
-			if err != nil {
-				util.Red.Printf("SKIPPING %s : %s\n", req.Path, err)
-			} else {
-				processedRequests = append(processedRequests, types.NewRequest(path, types.TypeFile, nil))
-			}
+		result := Download(ctx, req)
+		if result.Err != nil {
+			util.Red.Printf("SKIPPING %s : %s\n", req.Path, result.Err)
 		}
+		results = append(results, result)
 	}
-	return processedRequests
+	return results
 }
 
-func Mail(mailRequests []types.Request, timeout int) {
+// Mail sends every downloaded result in a single mail and returns the results with their final status
+func Mail(results []types.Result, timeout int) []types.Result {
 	var filePaths []string
-	for _, req := range mailRequests {
-		filePaths = append(filePaths, req.Path)
+	for _, result := range results {
+		if result.Status == types.StatusDownloaded {
+			filePaths = append(filePaths, result.Path)
+		}
+	}
+	if len(filePaths) == 0 {
+		return results
 	}
 	if timeout < 60 {
 		timeout = config.DefaultTimeout
 	}
+
+	var err error
 	// Use config singleton for backward compatibility
 	cfg := config.GetInstance()
-	if cfg != nil {
+	if cfg == nil {
+		err = fmt.Errorf("configuration not loaded")
+	} else {
 		mailSender := mail.NewSMTPMailSender(config.NewConfigProvider(cfg))
-		if err := mailSender.Send(filePaths, timeout); err != nil {
-			util.Red.Printf("Failed to send mail: %v\n", err)
+		err = mailSender.Send(filePaths, timeout)
+	}
+	if err != nil {
+		util.Red.Printf("Failed to send mail: %v\n", err)
+	}
+
+	mailed := make([]types.Result, len(results))
+	for i, result := range results {
+		if result.Status == types.StatusDownloaded {
+			if err != nil {
+				result.Status = types.StatusMailFailed
+				result.Err = err
+			} else {
+				result.Status = types.StatusSent
+			}
 		}
+		mailed[i] = result
 	}
+	return mailed
 }
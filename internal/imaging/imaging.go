@@ -0,0 +1,135 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Profile describes the screen images are prepared for
+type Profile struct {
+	// MaxWidth and MaxHeight bound the image size in pixels, 0 leaves that side unbounded
+	MaxWidth  int
+	MaxHeight int
+	// PPI is the pixel density of the screen, with MaxWidth and MaxHeight it gives the size of PDF pages
+	PPI int
+	// MarginMM is the blank border PDF pages keep around their text
+	MarginMM  float64
+	Grayscale bool
+	// Dither reduces images to the 16 grays of an e-ink panel with Floyd–Steinberg dithering, it implies Grayscale
+	Dither bool
+	// BudgetBytes caps the total size of the images in one book, 0 means no cap
+	BudgetBytes int64
+}
+
+// DefaultProfile is used when no device is configured
+const DefaultProfile = "kindle"
+
+// Profiles are presets for common readers, sized to their screens
+var Profiles = map[string]Profile{
+	"kindle":            {MaxWidth: 1072, MaxHeight: 1448, PPI: 300, MarginMM: 4, Grayscale: true},
+	"kindle-paperwhite": {MaxWidth: 1236, MaxHeight: 1648, PPI: 300, MarginMM: 4, Grayscale: true},
+	"kindle-oasis":      {MaxWidth: 1264, MaxHeight: 1680, PPI: 300, MarginMM: 4, Grayscale: true},
+	"kindle-scribe":     {MaxWidth: 1860, MaxHeight: 2480, PPI: 300, MarginMM: 8, Grayscale: true},
+	"kindle-colorsoft":  {MaxWidth: 1264, MaxHeight: 1680, PPI: 300, MarginMM: 4},
+	"kobo-clara":        {MaxWidth: 1072, MaxHeight: 1448, PPI: 300, MarginMM: 4, Grayscale: true},
+	"kobo-libra":        {MaxWidth: 1264, MaxHeight: 1680, PPI: 300, MarginMM: 4, Grayscale: true},
+	"remarkable":        {MaxWidth: 1404, MaxHeight: 1872, PPI: 226, MarginMM: 10, Grayscale: true},
+	"boox-note":         {MaxWidth: 1404, MaxHeight: 1872, PPI: 227, MarginMM: 10, Grayscale: true},
+	"tablet":            {MaxWidth: 1600, MaxHeight: 2560, PPI: 264, MarginMM: 8},
+	"original":          {},
+}
+
+// EInkLevels is the number of grays e-ink panels show
+const EInkLevels = 16
+
+// Apply fits img to the profile and converts its colors, returning img itself when nothing changes
+func (p Profile) Apply(img image.Image) image.Image {
+	img = Fit(img, p.MaxWidth, p.MaxHeight)
+	switch {
+	case p.Dither:
+		return Dither(img, EInkLevels)
+	case p.Grayscale:
+		if _, ok := img.(*image.Gray); ok {
+			return img
+		}
+		return Gray(img)
+	}
+	return img
+}
+
+// Fit scales img down to fit within maxWidth x maxHeight keeping its aspect ratio. It never scales up.
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	size := img.Bounds().Size()
+	factor := 1.0
+	if maxWidth > 0 && size.X > maxWidth {
+		factor = float64(maxWidth) / float64(size.X)
+	}
+	if maxHeight > 0 && size.Y > maxHeight {
+		factor = math.Min(factor, float64(maxHeight)/float64(size.Y))
+	}
+	if factor >= 1 {
+		return img
+	}
+	return Scale(img, factor)
+}
+
+// Scale resizes img by factor
+func Scale(img image.Image, factor float64) image.Image {
+	size := img.Bounds().Size()
+	rect := image.Rect(0, 0, max(1, int(float64(size.X)*factor)), max(1, int(float64(size.Y)*factor)))
+
+	var dst draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(rect)
+	} else {
+		dst = image.NewRGBA(rect)
+	}
+	draw.CatmullRom.Scale(dst, rect, img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// Gray converts img to grayscale, transparent areas become white like the page behind them
+func Gray(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Over)
+	return gray
+}
+
+// Dither reduces img to the given number of evenly spaced grays, spreading the rounding error
+// to neighbouring pixels with Floyd–Steinberg weights so gradients survive
+func Dither(img image.Image, levels int) *image.Gray {
+	gray := Gray(img)
+	if levels < 2 {
+		levels = 2
+	}
+	width, height := gray.Bounds().Dx(), gray.Bounds().Dy()
+	step := 255.0 / float64(levels-1)
+
+	// Errors for the current and the next row, with a pixel of padding on both sides
+	current := make([]float64, width+2)
+	next := make([]float64, width+2)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			offset := y*gray.Stride + x
+			value := float64(gray.Pix[offset]) + current[x+1]
+			quantized := math.Round(value/step) * step
+			quantized = math.Max(0, math.Min(255, quantized))
+			gray.Pix[offset] = uint8(quantized)
+
+			diff := value - quantized
+			current[x+2] += diff * 7 / 16
+			next[x] += diff * 3 / 16
+			next[x+1] += diff * 5 / 16
+			next[x+2] += diff * 1 / 16
+		}
+		current, next = next, current
+		clear(next)
+	}
+	return gray
+}
@@ -0,0 +1,93 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradient(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			v := uint8(x * 255 / (width - 1))
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height, maxWidth, maxHeight int
+		want                               image.Point
+	}{
+		{4000, 2000, 1072, 1448, image.Pt(1072, 536)},
+		{1000, 3000, 1072, 1448, image.Pt(482, 1448)},
+		{500, 400, 1072, 1448, image.Pt(500, 400)},
+		{4000, 2000, 0, 0, image.Pt(4000, 2000)},
+		{4000, 2000, 0, 500, image.Pt(1000, 500)},
+	}
+	for _, tt := range tests {
+		got := Fit(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), tt.maxWidth, tt.maxHeight).Bounds().Size()
+		if got != tt.want {
+			t.Errorf("Fit(%dx%d, %d, %d) = %v, want %v", tt.width, tt.height, tt.maxWidth, tt.maxHeight, got, tt.want)
+		}
+	}
+}
+
+func TestGrayFlattensTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(1, 0, color.NRGBA{0, 0, 0, 255})
+
+	gray := Gray(img)
+	if gray.GrayAt(0, 0).Y != 255 {
+		t.Errorf("transparent pixel became %d, want white", gray.GrayAt(0, 0).Y)
+	}
+	if gray.GrayAt(1, 0).Y != 0 {
+		t.Errorf("black pixel became %d", gray.GrayAt(1, 0).Y)
+	}
+}
+
+func TestDither(t *testing.T) {
+	src := gradient(256, 16)
+	dithered := Dither(src, EInkLevels)
+
+	levels := make(map[uint8]bool)
+	for _, v := range dithered.Pix {
+		if v%17 != 0 {
+			t.Fatalf("value %d is not one of the 16 e-ink grays", v)
+		}
+		levels[v] = true
+	}
+	if len(levels) < 8 {
+		t.Errorf("only %d grays used for a full gradient", len(levels))
+	}
+
+	// Error diffusion keeps the average brightness of the image
+	var before, after float64
+	plain := Gray(src)
+	for i := range plain.Pix {
+		before += float64(plain.Pix[i])
+		after += float64(dithered.Pix[i])
+	}
+	if diff := (after - before) / float64(len(plain.Pix)); diff > 2 || diff < -2 {
+		t.Errorf("average brightness moved by %.1f", diff)
+	}
+}
+
+func TestProfileApply(t *testing.T) {
+	img := gradient(3000, 100)
+
+	out := Profiles["kindle"].Apply(img)
+	if _, ok := out.(*image.Gray); !ok {
+		t.Errorf("kindle profile gave %T, want *image.Gray", out)
+	}
+	if out.Bounds().Dx() != 1072 {
+		t.Errorf("kindle profile width %d, want 1072", out.Bounds().Dx())
+	}
+
+	if out := Profiles["original"].Apply(img); out != image.Image(img) {
+		t.Error("original profile should return the image untouched")
+	}
+}
@@ -0,0 +1,134 @@
+package linkfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// netscapeDoctype opens the bookmark files every browser exports, it tells them apart from saved pages
+var netscapeDoctype = []byte("<!DOCTYPE NETSCAPE-Bookmark-file-1>")
+
+// parseNetscape reads the links of an exported bookmarks.html
+func parseNetscape(data []byte) ([]string, bool) {
+	head := bytes.TrimSpace(data)
+	if len(head) < len(netscapeDoctype) || !bytes.EqualFold(head[:len(netscapeDoctype)], netscapeDoctype) {
+		return nil, false
+	}
+
+	var links []string
+	tokens := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			return links, true
+		case html.StartTagToken:
+			token := tokens.Token()
+			if token.Data != "a" {
+				continue
+			}
+			for _, a := range token.Attr {
+				if a.Key == "href" && isWebLink(a.Val) {
+					links = append(links, a.Val)
+				}
+			}
+		}
+	}
+}
+
+type opmlOutline struct {
+	// Reading lists give the page as url, feed subscriptions give the site next to its feed as htmlUrl
+	URL      string        `xml:"url,attr"`
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlDocument struct {
+	XMLName xml.Name `xml:"opml"`
+	Body    struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+// parseOPML reads the links of the outlines of an OPML file, nested ones included
+func parseOPML(data []byte) ([]string, bool) {
+	var doc opmlDocument
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, false
+	}
+
+	var links []string
+	var walk func([]opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			for _, link := range []string{o.URL, o.HTMLURL} {
+				if isWebLink(link) {
+					links = append(links, link)
+					break
+				}
+			}
+			walk(o.Outlines)
+		}
+	}
+	walk(doc.Body.Outlines)
+	return links, true
+}
+
+// browserNode is a bookmark or folder of a Chrome (and Edge, Brave...) Bookmarks file or a Firefox JSON backup
+type browserNode struct {
+	Type     string                 `json:"type"`
+	URL      string                 `json:"url"`
+	URI      string                 `json:"uri"`
+	Children []browserNode          `json:"children"`
+	Roots    map[string]browserNode `json:"roots"`
+}
+
+// chromeRoots lists Chrome's top level folders in the order it shows them
+var chromeRoots = []string{"bookmark_bar", "other", "synced"}
+
+// parseBrowserJSON reads the links of a browser's JSON bookmarks, in the order of their folders
+func parseBrowserJSON(data []byte) ([]string, bool) {
+	var root browserNode
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, false
+	}
+	if len(root.Roots) == 0 && !strings.HasPrefix(root.Type, "text/x-moz-place") {
+		return nil, false
+	}
+
+	var links []string
+	var walk func(browserNode)
+	walk = func(node browserNode) {
+		// Firefox also keeps place: queries among its bookmarks, only web links are kept
+		for _, link := range []string{node.URL, node.URI} {
+			if isWebLink(link) {
+				links = append(links, link)
+			}
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+
+	names := make([]string, 0, len(root.Roots))
+	for name := range root.Roots {
+		if !slices.Contains(chromeRoots, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range slices.Concat(chromeRoots, names) {
+		if node, ok := root.Roots[name]; ok {
+			walk(node)
+		}
+	}
+	walk(root)
+	return links, true
+}
@@ -0,0 +1,113 @@
+// Package linkfile reads the links out of files listing webpages: plain lists of links, OPML outlines and the
+// bookmark exports of browsers.
+package linkfile
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Kind is the layout of a file of links
+type Kind string
+
+const (
+	KindList        Kind = "list of links"
+	KindOPML        Kind = "OPML outline"
+	KindNetscape    Kind = "Netscape bookmarks file"
+	KindBrowserJSON Kind = "browser bookmarks export"
+)
+
+// Parse recognises the kind of a file of links from its content and returns its http(s) links in order, without
+// duplicates. Kind is empty when data isn't a file of links. An export may be recognised and still hold no links.
+func Parse(data []byte) (Kind, []string) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	parsers := []struct {
+		kind  Kind
+		parse func([]byte) ([]string, bool)
+	}{
+		{KindNetscape, parseNetscape},
+		{KindOPML, parseOPML},
+		{KindBrowserJSON, parseBrowserJSON},
+		{KindList, parseList},
+	}
+	for _, p := range parsers {
+		if links, ok := p.parse(data); ok {
+			return p.kind, unique(links)
+		}
+	}
+	return "", nil
+}
+
+// Read returns the links of the file at path, failing when it isn't a file of links or has none
+func Read(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kind, links := Parse(data)
+	switch {
+	case kind == "":
+		return nil, fmt.Errorf("%s is not a file of links", path)
+	case len(links) == 0:
+		return nil, fmt.Errorf("%s is a %s without any web links", path, kind)
+	}
+	return links, nil
+}
+
+// markdownLink matches [title](link), the link may hold parentheses a bare link would lose
+var markdownLink = regexp.MustCompile(`\[[^\]]*\]\((https?://\S+)\)`)
+
+// parseList reads a file with a link on every line. Lines may add a title or a note around the link, or be
+// written as Markdown links or list items. Blank lines and lines starting with # or // are comments.
+func parseList(data []byte) ([]string, bool) {
+	var links []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		link := lineLink(line)
+		if link == "" {
+			// Prose with the odd link in it is a document, not a list
+			return nil, false
+		}
+		links = append(links, link)
+	}
+	return links, len(links) > 0
+}
+
+// lineLink returns the link on a line of a list, "" when there's none
+func lineLink(line string) string {
+	if m := markdownLink.FindStringSubmatch(line); m != nil && isWebLink(m[1]) {
+		return m[1]
+	}
+	for _, field := range strings.Fields(line) {
+		field = strings.Trim(field, `<>"'`)
+		if isWebLink(field) {
+			return field
+		}
+	}
+	return ""
+}
+
+// isWebLink reports whether link is an http(s) link to a host
+func isWebLink(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func unique(links []string) []string {
+	seen := make(map[string]bool)
+	kept := make([]string, 0, len(links))
+	for _, link := range links {
+		if !seen[link] {
+			seen[link] = true
+			kept = append(kept, link)
+		}
+	}
+	return kept
+}
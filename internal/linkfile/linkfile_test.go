@@ -0,0 +1,70 @@
+package linkfile
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseFixtures(t *testing.T) {
+	tests := []struct {
+		file string
+		kind Kind
+		want []string
+	}{
+		{"bookmarks.html", KindNetscape, []string{"https://example.com/first", "https://example.com/second"}},
+		{"reading.opml", KindOPML, []string{"https://example.com/first", "https://example.org/"}},
+		{"chrome-bookmarks.json", KindBrowserJSON, []string{"https://example.com/first", "https://example.com/second"}},
+		{"firefox-bookmarks.json", KindBrowserJSON, []string{"https://example.com/first", "https://example.com/second"}},
+		{"links.md", KindList, []string{
+			"https://example.com/first",
+			"https://example.com/second",
+			"https://en.wikipedia.org/wiki/Go_(programming_language)",
+			"https://en.wikipedia.org/wiki/Lisp_(programming_language)",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kind, links := Parse(data)
+			if kind != tt.kind {
+				t.Errorf("kind is %q, want %q", kind, tt.kind)
+			}
+			if !slices.Equal(links, tt.want) {
+				t.Errorf("links are %q, want %q", links, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDocuments(t *testing.T) {
+	for _, doc := range []string{
+		"Dear reader,\nthe article is at https://example.com/post but this is a letter.\n",
+		"<!DOCTYPE html><html><body><a href=\"https://example.com\">a saved page</a></body></html>",
+		`{"name": "package.json", "version": "1.0.0"}`,
+		"<?xml version=\"1.0\"?><rss><channel><link>https://example.com</link></channel></rss>",
+		"",
+	} {
+		if kind, _ := Parse([]byte(doc)); kind != "" {
+			t.Errorf("%q was read as a %s", doc, kind)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.opml")
+	if err := os.WriteFile(empty, []byte("<opml><body><outline text=\"nothing\"/></body></opml>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(empty); err == nil {
+		t.Error("OPML without links was read")
+	}
+	if links, err := Read(filepath.Join("testdata", "links.md")); err != nil || len(links) != 4 {
+		t.Errorf("got %q, %v", links, err)
+	}
+}
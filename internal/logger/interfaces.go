@@ -1,6 +1,9 @@
 package logger
 
 import (
+	"log"
+	"os"
+
 	"github.com/ryan-gang/kindle-send-daemon/internal/config"
 )
 
@@ -25,3 +28,14 @@ func NewLogger(cfg config.ConfigProvider) (LoggerInterface, error) {
 	}
 	return logger, nil
 }
+
+// NewConsoleLogger creates a logger that only writes to stderr, for commands that read the daemon's state
+// without being the daemon
+func NewConsoleLogger() LoggerInterface {
+	return &Logger{
+		infoLogger:  log.New(os.Stderr, "INFO:  ", log.Ldate|log.Ltime),
+		warnLogger:  log.New(os.Stderr, "WARN:  ", log.Ldate|log.Ltime),
+		errorLogger: log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime),
+		debugLogger: log.New(os.Stderr, "DEBUG: ", log.Ldate|log.Ltime),
+	}
+}
@@ -0,0 +1,81 @@
+package simhash
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// shingleSize is the number of consecutive words hashed together, single words make unrelated texts look alike
+const shingleSize = 3
+
+// MinWords is the shortest text worth fingerprinting, shorter texts collide too easily
+const MinWords = 50
+
+// DefaultThreshold is how many bits two fingerprints may differ by and still count as the same text.
+// Copies of an article with a different header or footer usually land within a few bits, unrelated
+// articles around 32 bits apart.
+const DefaultThreshold = 6
+
+// Fingerprint is a 64 bit SimHash, texts that share most of their wording differ in only a few bits.
+// The zero value means no fingerprint.
+type Fingerprint uint64
+
+// Of returns the fingerprint of text, or 0 when it has fewer than MinWords words
+func Of(text string) Fingerprint {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < MinWords {
+		return 0
+	}
+
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return Fingerprint(fingerprint)
+}
+
+// Distance is the number of differing bits between two fingerprints
+func (f Fingerprint) Distance(other Fingerprint) int {
+	return bits.OnesCount64(uint64(f ^ other))
+}
+
+// Near reports whether both fingerprints are set and at most threshold bits apart
+func (f Fingerprint) Near(other Fingerprint, threshold int) bool {
+	return f != 0 && other != 0 && f.Distance(other) <= threshold
+}
+
+// MarshalText writes the fingerprint as hex, JSON numbers lose precision above 2^53 in most readers
+func (f Fingerprint) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%016x", uint64(f))), nil
+}
+
+func (f *Fingerprint) UnmarshalText(text []byte) error {
+	value, err := strconv.ParseUint(string(text), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid fingerprint %q: %w", text, err)
+	}
+	*f = Fingerprint(value)
+	return nil
+}
@@ -0,0 +1,64 @@
+package simhash
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const article = `The city council voted on Tuesday to expand the bike lane network across the downtown core,
+adding nearly twelve miles of protected lanes over the next three years. Supporters said the plan would make
+commuting safer for thousands of residents who already ride to work, while opponents worried about the loss of
+street parking for small businesses along the main shopping corridor. The transportation department will hold
+public meetings this spring to gather feedback on the exact routes before construction begins next year.
+Council members spent most of the four hour session debating how the project should be paid for. The current
+proposal draws on a mix of federal grants, a state infrastructure fund and a small increase in downtown parking
+fees, which business owners said would hit their customers twice. Several speakers during public comment asked
+the council to phase the work so that no single block loses all of its parking at once. Cycling advocates
+pointed to data from the past two years showing that crashes involving riders fell by almost a third on the
+streets that already have protected lanes, and argued that the remaining gaps in the network are exactly where
+the most serious injuries now happen. The mayor, who campaigned on safer streets, called the vote a turning
+point and promised that the first segments would open before the end of next summer. Engineers expect to start
+with the east side connector, which links two existing lanes and the main bus station, because it needs the
+fewest changes to traffic signals. A citizen advisory group will review each design and publish its notes.`
+
+func TestNearDuplicates(t *testing.T) {
+	original := Of(article)
+	if original == 0 {
+		t.Fatal("expected a fingerprint for a full paragraph")
+	}
+
+	syndicated := Of("Reposted from the Daily Herald. " + strings.Replace(article, "Tuesday", "Monday", 1) + " Share this story with a friend.")
+	if d := original.Distance(syndicated); d > DefaultThreshold {
+		t.Errorf("syndicated copy is %d bits away, want at most %d", d, DefaultThreshold)
+	}
+
+	unrelated := Of(strings.Repeat("Quarterly earnings beat analyst expectations as cloud revenue grew sharply while hardware sales declined. ", 4))
+	if original.Near(unrelated, DefaultThreshold) {
+		t.Errorf("unrelated text is only %d bits away", original.Distance(unrelated))
+	}
+}
+
+func TestShortTextHasNoFingerprint(t *testing.T) {
+	if f := Of("Too short to tell anything apart"); f != 0 {
+		t.Errorf("got %x for a short text", f)
+	}
+	if Fingerprint(0).Near(0, 3) {
+		t.Error("missing fingerprints must never match")
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	f := Fingerprint(0xfedcba9876543210)
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"fedcba9876543210"` {
+		t.Errorf("marshalled as %s", data)
+	}
+	var back Fingerprint
+	if err := json.Unmarshal(data, &back); err != nil || back != f {
+		t.Errorf("round trip gave %x, %v", back, err)
+	}
+}
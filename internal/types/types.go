@@ -1,11 +1,15 @@
 package types
 
+import "github.com/ryan-gang/kindle-send-daemon/internal/simhash"
+
 type FileType string
 
 var (
 	TypeUrl     FileType = "url"
 	TypeUrlFile FileType = "urlfile"
 	TypeFile    FileType = "file"
+	// TypeLocalDocument is a local HTML, Markdown or text file converted like a fetched page
+	TypeLocalDocument FileType = "localdocument"
 )
 
 type Request struct {
@@ -14,6 +18,41 @@ type Request struct {
 	Options map[string]string
 }
 
+// OptionFormat is the request option naming the output format, the configured default is used without it
+const OptionFormat = "format"
+
 func NewRequest(path string, fileType FileType, opts map[string]string) Request {
 	return Request{path, fileType, opts}
 }
+
+// Status is the outcome of a request as it moves through download and mail
+type Status string
+
+var (
+	StatusDownloaded     Status = "downloaded"
+	StatusSent           Status = "sent"
+	StatusDownloadFailed Status = "download_failed"
+	StatusMailFailed     Status = "mail_failed"
+	StatusSkipped        Status = "skipped"
+)
+
+// Result records what happened to a single request
+type Result struct {
+	Request Request
+	// Path, Title and Size describe the file produced for the request, empty if it failed to download
+	Path  string
+	Title string
+	Size  int64
+	// Fingerprint identifies the article text of single article downloads, see simhash
+	Fingerprint simhash.Fingerprint
+	Status      Status
+	Err         error
+}
+
+// ErrText returns the failure text, empty when the request succeeded
+func (r Result) ErrText() string {
+	if r.Err == nil {
+		return ""
+	}
+	return r.Err.Error()
+}
@@ -35,23 +35,3 @@ func Scanline() string {
 func ScanlineTrim() string {
 	return strings.TrimSpace(Scanline())
 }
-
-// ExtractLinks extracts links from a file containing urls
-func ExtractLinks(filename string) (links []string) {
-	links = make([]string, 0)
-	file, err := os.Open(filename)
-	if err != nil {
-		Red.Println("Error opening link file", err)
-		return
-	}
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		if len(scanner.Text()) == 0 {
-			continue
-		}
-		links = append(links, scanner.Text())
-	}
-	return
-}